@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"devoops/contract"
+)
+
+// One method per API route, in the order newRouter registers them.
+
+func (c *Client) Health(ctx context.Context) (contract.HealthResponse, error) {
+	var health contract.HealthResponse
+	_, err := c.do(ctx, call{method: http.MethodGet, path: "/healthz", result: &health})
+	return health, err
+}
+
+// Ready also returns the checks when the API is not ready, with a 503 error.
+func (c *Client) Ready(ctx context.Context) (contract.HealthResponse, error) {
+	var health contract.HealthResponse
+	_, err := c.do(ctx, call{method: http.MethodGet, path: "/readyz", result: &health,
+		accept: []int{http.StatusServiceUnavailable}})
+	if err == nil && health.Status != contract.HEALTH_OK {
+		err = &Error{contract.APIError{Status: http.StatusServiceUnavailable, ErrorMsg: "API is not ready"}}
+	}
+	return health, err
+}
+
+// Latest returns the latest action id the simulator sent, -1 before the first.
+func (c *Client) Latest(ctx context.Context) (int, error) {
+	var latest contract.LatestResponse
+	_, err := c.do(ctx, call{method: http.MethodGet, path: "/latest", result: &latest})
+	return latest.Latest, err
+}
+
+func (c *Client) Register(ctx context.Context, req contract.RegisterRequest) error {
+	_, err := c.do(ctx, call{method: http.MethodPost, path: "/register", body: req})
+	return err
+}
+
+// Follow makes username follow whom. Following yourself fails with ErrBadRequest and
+// the ERR_CANNOT_FOLLOW_SELF code.
+func (c *Client) Follow(ctx context.Context, username, whom string) (contract.FollowResponse, error) {
+	return c.follow(ctx, username, contract.FollowRequest{Follow: whom})
+}
+
+func (c *Client) Unfollow(ctx context.Context, username, whom string) (contract.FollowResponse, error) {
+	return c.follow(ctx, username, contract.FollowRequest{Unfollow: whom})
+}
+
+func (c *Client) follow(ctx context.Context, username string, body contract.FollowRequest) (contract.FollowResponse, error) {
+	var response contract.FollowResponse
+	_, err := c.do(ctx, call{method: http.MethodPost, path: "/fllws/" + url.PathEscape(username), body: body,
+		result: &response})
+	return response, err
+}
+
+// Follows lists the users username follows.
+func (c *Client) Follows(ctx context.Context, username string, page Page) (contract.FollowsResponse, error) {
+	var follows contract.FollowsResponse
+	_, err := c.do(ctx, call{method: http.MethodGet, path: "/fllws/" + url.PathEscape(username),
+		query: page.query(), result: &follows})
+	return follows, err
+}
+
+func (c *Client) Messages(ctx context.Context, page Page) (MessagePage, error) {
+	return c.messages(ctx, "/msgs", page.query())
+}
+
+func (c *Client) UserMessages(ctx context.Context, username string, page Page) (MessagePage, error) {
+	return c.messages(ctx, "/msgs/"+url.PathEscape(username), page.query())
+}
+
+func (c *Client) PostMessage(ctx context.Context, username, content string) error {
+	_, err := c.do(ctx, call{method: http.MethodPost, path: "/msgs/" + url.PathEscape(username),
+		body: contract.MessageRequest{Content: content}})
+	return err
+}
+
+func (c *Client) Message(ctx context.Context, id uint) (contract.Message, error) {
+	var message contract.Message
+	_, err := c.do(ctx, call{method: http.MethodGet, path: "/msg/" + strconv.FormatUint(uint64(id), 10), result: &message})
+	return message, err
+}
+
+// Timeline lists the messages by userID and the users they follow. It acts on behalf of
+// the user, so ctx must carry their token, see WithToken.
+func (c *Client) Timeline(ctx context.Context, userID uint, page Page) (MessagePage, error) {
+	query := page.query()
+	query.Set("userid", strconv.FormatUint(uint64(userID), 10))
+	return c.messages(ctx, "/followingmsgs", query)
+}
+
+func (c *Client) Search(ctx context.Context, q string, page Page) (MessagePage, error) {
+	query := page.query()
+	query.Set("q", q)
+	return c.messages(ctx, "/search", query)
+}
+
+func (c *Client) messages(ctx context.Context, path string, query url.Values) (MessagePage, error) {
+	var page MessagePage
+	header, err := c.do(ctx, call{method: http.MethodGet, path: path, query: query, result: &page.Messages})
+	if err == nil {
+		page.Cursors = Cursors{Next: header.Get("X-Next-Cursor"), Prev: header.Get("X-Prev-Cursor")}
+	}
+	return page, err
+}
+
+func (c *Client) FlaggedMessages(ctx context.Context, page Page) ([]contract.FlaggedMessage, Cursors, error) {
+	var messages []contract.FlaggedMessage
+	header, err := c.do(ctx, call{method: http.MethodGet, path: "/admin/msgs/flagged", query: page.query(), result: &messages})
+	if err != nil {
+		return nil, Cursors{}, err
+	}
+	return messages, Cursors{Next: header.Get("X-Next-Cursor"), Prev: header.Get("X-Prev-Cursor")}, nil
+}
+
+func (c *Client) FlagMessage(ctx context.Context, id uint, reason string) error {
+	return c.moderate(ctx, id, "flag", reason)
+}
+
+func (c *Client) UnflagMessage(ctx context.Context, id uint, reason string) error {
+	return c.moderate(ctx, id, "unflag", reason)
+}
+
+func (c *Client) moderate(ctx context.Context, id uint, action, reason string) error {
+	path := "/admin/msgs/" + strconv.FormatUint(uint64(id), 10) + "/" + action
+	_, err := c.do(ctx, call{method: http.MethodPost, path: path, body: contract.FlagRequest{Reason: reason}})
+	return err
+}
+
+func (c *Client) UserByID(ctx context.Context, id uint) (contract.UserDetails, error) {
+	return c.userDetails(ctx, url.Values{"user_id": {strconv.FormatUint(uint64(id), 10)}})
+}
+
+func (c *Client) UserByUsername(ctx context.Context, username string) (contract.UserDetails, error) {
+	return c.userDetails(ctx, url.Values{"username": {username}})
+}
+
+func (c *Client) userDetails(ctx context.Context, query url.Values) (contract.UserDetails, error) {
+	var user contract.UserDetails
+	_, err := c.do(ctx, call{method: http.MethodGet, path: "/getUserDetails", query: query, result: &user})
+	return user, err
+}
+
+func (c *Client) IsFollowing(ctx context.Context, who, whom string) (bool, error) {
+	var following bool
+	_, err := c.do(ctx, call{method: http.MethodGet, path: "/isfollowing",
+		query: url.Values{"whoUsername": {who}, "whomUsername": {whom}}, result: &following})
+	return following, err
+}
+
+// Login checks a user's password and returns the token to act on their behalf with.
+func (c *Client) Login(ctx context.Context, username, password string) (contract.LoginResponse, error) {
+	var login contract.LoginResponse
+	_, err := c.do(ctx, call{method: http.MethodPost, path: "/login",
+		body: contract.LoginRequest{Username: username, Password: password}, result: &login})
+	return login, err
+}
+
+func (p Page) query() url.Values {
+	query := url.Values{}
+	if p.Before != "" {
+		query.Set("before", p.Before)
+	}
+	if p.After != "" {
+		query.Set("after", p.After)
+	}
+	if p.Limit > 0 {
+		query.Set("no", strconv.Itoa(p.Limit))
+	}
+	return query
+}
@@ -0,0 +1,235 @@
+// Package client is a typed client for the MiniTwit API, shared by the frontend and the
+// end-to-end tests.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"devoops/contract"
+)
+
+const (
+	// DEFAULT_TIMEOUT bounds a single attempt of a call, retries get their own.
+	DEFAULT_TIMEOUT = 10 * time.Second
+	// DEFAULT_RETRIES is how often idempotent calls are retried after a network error or
+	// a 502, 503 or 504, waiting DEFAULT_BACKOFF, then twice as long, and so on.
+	DEFAULT_RETRIES = 2
+	DEFAULT_BACKOFF = 100 * time.Millisecond
+)
+
+const (
+	SERVICE_TOKEN_HEADER = "X-Service-Token"
+	REQUEST_ID_HEADER    = contract.REQUEST_ID_HEADER
+)
+
+// Client calls the API at one base URL. It is safe for concurrent use.
+type Client struct {
+	baseURL      string
+	httpClient   *http.Client
+	serviceToken string
+	username     string
+	password     string
+	retries      int
+	backoff      time.Duration
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces the http.Client, e.g. to share a transport.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.httpClient.Timeout = timeout }
+}
+
+// WithServiceToken authenticates as the frontend service.
+func WithServiceToken(token string) Option {
+	return func(c *Client) { c.serviceToken = token }
+}
+
+// WithBasicAuth authenticates as the simulator or as an admin.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) { c.username, c.password = username, password }
+}
+
+// WithRetries sets how often idempotent calls are retried, 0 disables retries.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) { c.retries, c.backoff = retries, backoff }
+}
+
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: DEFAULT_TIMEOUT},
+		retries:    DEFAULT_RETRIES,
+		backoff:    DEFAULT_BACKOFF,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+type contextKey int
+
+const (
+	tokenKey contextKey = iota
+	requestIDKey
+	latestKey
+)
+
+// WithToken makes the calls made with ctx on behalf of the user the token was issued to.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey, token)
+}
+
+// WithRequestID sends id as X-Request-ID, so API logs can be matched with the caller's.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// WithLatest sends the simulator's action id as ?latest= with the calls made with ctx.
+func WithLatest(ctx context.Context, latest int) context.Context {
+	return context.WithValue(ctx, latestKey, latest)
+}
+
+// call describes one API request. Statuses in accept are decoded into result like a
+// 2xx, for routes that answer a failure with their regular body.
+type call struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	result interface{}
+	accept []int
+}
+
+// do sends the call and returns the response headers. GET calls are retried on network
+// errors and on 502, 503 and 504, other methods are sent once.
+func (c *Client) do(ctx context.Context, req call) (http.Header, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return nil, err
+		}
+	}
+
+	attempts := 1
+	if req.method == http.MethodGet {
+		attempts += c.retries
+	}
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(c.backoff << (attempt - 1)):
+			}
+		}
+		header, err := c.send(ctx, req, body)
+		if err == nil || !retryable(ctx, err) {
+			return header, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (c *Client) send(ctx context.Context, req call, body []byte) (http.Header, error) {
+	query := url.Values{}
+	for key, values := range req.query {
+		query[key] = values
+	}
+	if latest, ok := ctx.Value(latestKey).(int); ok {
+		query.Set("latest", strconv.Itoa(latest))
+	}
+	target := c.baseURL + req.path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	c.authenticate(ctx, httpReq)
+
+	res, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 && !accepted(res.StatusCode, req.accept) {
+		return res.Header, readError(res)
+	}
+	if req.result != nil && res.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(res.Body).Decode(req.result); err != nil {
+			return res.Header, fmt.Errorf("decoding %s %s: %w", req.method, req.path, err)
+		}
+	}
+	return res.Header, nil
+}
+
+func (c *Client) authenticate(ctx context.Context, req *http.Request) {
+	if c.serviceToken != "" {
+		req.Header.Set(SERVICE_TOKEN_HEADER, c.serviceToken)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	if token, _ := ctx.Value(tokenKey).(string); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if id, _ := ctx.Value(requestIDKey).(string); id != "" {
+		req.Header.Set(REQUEST_ID_HEADER, id)
+	}
+}
+
+func accepted(status int, accept []int) bool {
+	for _, s := range accept {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// retryable reports whether err may be gone on the next attempt. A cancelled or expired
+// context is final.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Status {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"devoops/contract"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return New(server.URL+"/", WithServiceToken("service"), WithRetries(2, time.Millisecond))
+}
+
+func TestIdempotentCallsAreRetried(t *testing.T) {
+	attempts := map[string]int{}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts[r.Method]++
+		if attempts[r.Method] < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"latest":4}`))
+	})
+
+	latest, err := c.Latest(context.Background())
+	if err != nil || latest != 4 {
+		t.Fatalf("got %d, %v after retries", latest, err)
+	}
+	err = c.PostMessage(context.Background(), "alice", "hello")
+	if !errors.Is(err, ErrUnavailable) || attempts[http.MethodPost] != 1 {
+		t.Fatalf("POST was sent %d times, got %v", attempts[http.MethodPost], err)
+	}
+}
+
+func TestErrorsAreTyped(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/getUserDetails" || r.Header.Get(SERVICE_TOKEN_HEADER) != "service" ||
+			r.Header.Get(REQUEST_ID_HEADER) != "abc" {
+			t.Errorf("unexpected request %s %v", r.URL, r.Header)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"status":404,"code":"user_not_found","error_msg":"Cannot find user","request_id":"abc"}`))
+	})
+
+	_, err := c.UserByUsername(WithRequestID(context.Background(), "abc"), "nobody")
+	var apiErr *Error
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &apiErr) || apiErr.Code != contract.ERR_USER_NOT_FOUND || apiErr.RequestID != "abc" {
+		t.Fatalf("unexpected error %#v", err)
+	}
+}
+
+func TestFollowingYourselfIsAnError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.URL.Query().Get("latest") != "9" {
+			t.Errorf("unexpected request %s %v", r.URL, r.Header)
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"status":422,"code":"cannot_follow_self","error_msg":"You cannot follow yourself"}`))
+	})
+
+	ctx := WithLatest(WithToken(context.Background(), "token"), 9)
+	_, err := c.Follow(ctx, "alice", "alice")
+	var apiErr *Error
+	if !errors.Is(err, ErrBadRequest) || !errors.As(err, &apiErr) || apiErr.Code != contract.ERR_CANNOT_FOLLOW_SELF {
+		t.Fatalf("unexpected error %#v", err)
+	}
+}
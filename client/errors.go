@@ -0,0 +1,66 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"devoops/contract"
+)
+
+// Status classes an *Error matches with errors.Is.
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrUnavailable  = errors.New("unavailable")
+)
+
+// Error is a failed API call. Code is empty when the response was not an API error,
+// e.g. from a proxy in front of the API.
+type Error struct {
+	contract.APIError
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("API answered %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("API answered %d: %s (%s)", e.Status, e.ErrorMsg, e.Code)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.Status == http.StatusBadRequest || e.Status == http.StatusRequestEntityTooLarge ||
+			e.Status == http.StatusUnprocessableEntity
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.Status == http.StatusForbidden
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrConflict:
+		return e.Status == http.StatusConflict
+	case ErrUnavailable:
+		return e.Status >= http.StatusInternalServerError
+	}
+	return false
+}
+
+func readError(res *http.Response) error {
+	apiErr := &Error{}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if json.Unmarshal(body, &apiErr.APIError) != nil {
+		apiErr = &Error{}
+	}
+	apiErr.Status = res.StatusCode
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = res.Header.Get(REQUEST_ID_HEADER)
+	}
+	return apiErr
+}
@@ -0,0 +1,7 @@
+module devoops/client
+
+go 1.23.6
+
+require devoops/contract v0.0.0
+
+replace devoops/contract => ../contract
@@ -0,0 +1,22 @@
+package client
+
+import "devoops/contract"
+
+// Page selects a window of a listing. Before and After take the cursors of a previous
+// page and cannot be combined, Limit 0 leaves the size to the API.
+type Page struct {
+	Before string
+	After  string
+	Limit  int
+}
+
+// Cursors point at the neighbouring pages, empty when there is nothing to page to.
+type Cursors struct {
+	Next string // older rows
+	Prev string // newer rows
+}
+
+type MessagePage struct {
+	Messages []contract.Message
+	Cursors
+}
@@ -0,0 +1,148 @@
+// Package config loads the configuration of the MiniTwit services. A service describes its
+// settings as a struct: the yaml tag names a setting in the configuration file, the env
+// tag the environment variable overriding it, and secret:"true" keeps it out of Write.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// REDACTED replaces the value of secrets that are set.
+const REDACTED = "<redacted>"
+
+// The modes a service runs in. Outside MODE_DEV the services refuse to start without the
+// secrets they share, as a missing one breaks the frontend or logs users out on restart.
+const (
+	MODE_PRODUCTION = "production"
+	MODE_DEV        = "dev"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Load fills cfg, a pointer to a struct holding the defaults, from the YAML file at path
+// and then from the environment. Unknown keys in the file are an error, so a typo does
+// not silently leave a default in place. An empty path reads no file, and so does an
+// empty environment variable.
+func Load(path string, cfg interface{}) error {
+	target := reflect.ValueOf(cfg)
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: %T is not a pointer to a struct", cfg)
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return applyEnv(target.Elem())
+}
+
+func applyEnv(v reflect.Value) error {
+	var errs []error
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		if value.Kind() == reflect.Struct {
+			errs = append(errs, applyEnv(value))
+			continue
+		}
+		name := field.Tag.Get("env")
+		if name == "" || os.Getenv(name) == "" {
+			continue
+		}
+		if err := setValue(value, os.Getenv(name)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setValue(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(int64(n))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// Write prints cfg as YAML in the layout Load reads, with the secrets that are set
+// replaced by REDACTED.
+func Write(w io.Writer, cfg interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(cfg))
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("config: %T is not a struct", cfg)
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node(v)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// node builds the YAML of a struct in field order, with durations written the way
+// time.ParseDuration reads them.
+func node(v reflect.Value) *yaml.Node {
+	mapping := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		name := field.Tag.Get("yaml")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: name}
+		if env := field.Tag.Get("env"); env != "" {
+			key.LineComment = env
+		}
+
+		var item *yaml.Node
+		switch {
+		case value.Kind() == reflect.Struct:
+			item = node(value)
+		case field.Tag.Get("secret") == "true" && !value.IsZero():
+			item = &yaml.Node{Kind: yaml.ScalarNode, Value: REDACTED}
+		case value.Type() == durationType:
+			item = &yaml.Node{Kind: yaml.ScalarNode, Value: time.Duration(value.Int()).String()}
+		default:
+			item = &yaml.Node{}
+			if err := item.Encode(value.Interface()); err != nil {
+				item = &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(value.Interface())}
+			}
+		}
+		mapping.Content = append(mapping.Content, key, item)
+	}
+	return mapping
+}
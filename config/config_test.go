@@ -0,0 +1,111 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Port    string        `yaml:"port" env:"TEST_PORT"`
+	Debug   bool          `yaml:"debug" env:"TEST_DEBUG"`
+	Workers int           `yaml:"workers" env:"TEST_WORKERS"`
+	Timeout time.Duration `yaml:"timeout" env:"TEST_TIMEOUT"`
+	Store   struct {
+		Path     string `yaml:"path" env:"TEST_STORE_PATH"`
+		Password string `yaml:"password" env:"TEST_STORE_PASSWORD" secret:"true"`
+	} `yaml:"store"`
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Defaults are overridden by the file, and the file by the environment.
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "port: \":8000\"\nworkers: 4\ntimeout: 30s\nstore:\n  path: /data/file.db\n")
+	t.Setenv("TEST_WORKERS", "8")
+	t.Setenv("TEST_DEBUG", "true")
+	t.Setenv("TEST_PORT", "") // empty means unset
+
+	cfg := testConfig{Port: ":80", Workers: 1, Timeout: time.Second}
+	cfg.Store.Path = "default.db"
+	if err := Load(path, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != ":8000" || cfg.Workers != 8 || !cfg.Debug || cfg.Timeout != 30*time.Second || cfg.Store.Path != "/data/file.db" {
+		t.Fatalf("unexpected configuration %+v", cfg)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	cases := map[string]func(t *testing.T) string{
+		"unknown key": func(t *testing.T) string { return writeFile(t, "prot: \":8000\"\n") },
+		"missing file": func(t *testing.T) string {
+			return filepath.Join(t.TempDir(), "missing.yaml")
+		},
+		"bad duration": func(t *testing.T) string {
+			t.Setenv("TEST_TIMEOUT", "soon")
+			return ""
+		},
+		"bad integer": func(t *testing.T) string {
+			t.Setenv("TEST_WORKERS", "many")
+			return ""
+		},
+	}
+	for name, setup := range cases {
+		t.Run(name, func(t *testing.T) {
+			var cfg testConfig
+			if err := Load(setup(t), &cfg); err == nil {
+				t.Fatalf("expected an error, got %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestWriteRedactsSecrets(t *testing.T) {
+	cfg := testConfig{Port: ":80", Timeout: 90 * time.Second}
+	cfg.Store.Password = "hunter2"
+
+	var out strings.Builder
+	if err := Write(&out, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "hunter2") || !strings.Contains(out.String(), REDACTED) {
+		t.Fatalf("secret was not redacted:\n%s", out.String())
+	}
+
+	// The output reads back as a configuration file
+	var loaded testConfig
+	if err := Load(writeFile(t, out.String()), &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Port != ":80" || loaded.Timeout != 90*time.Second {
+		t.Fatalf("output does not read back: %+v", loaded)
+	}
+}
+
+func TestServerConfigValidate(t *testing.T) {
+	if err := DefaultServerConfig().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (ServerConfig{}).Validate(); err == nil {
+		t.Fatal("expected an error for zero timeouts")
+	}
+	cfg := DefaultServerConfig()
+	cfg.PreShutdownDelay = -time.Second
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an error for a negative pre-shutdown delay")
+	}
+	cfg.PreShutdownDelay = 0
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("no pre-shutdown delay: %v", err)
+	}
+}
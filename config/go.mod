@@ -0,0 +1,5 @@
+module devoops/config
+
+go 1.23.6
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// Defaults of the HTTP server timeouts. PRE_SHUTDOWN_DELAY plus SHUTDOWN_TIMEOUT has to
+// stay below the grace period the container runtime gives before killing the process.
+const (
+	DEFAULT_READ_HEADER_TIMEOUT = 5 * time.Second
+	DEFAULT_READ_TIMEOUT        = 15 * time.Second
+	DEFAULT_WRITE_TIMEOUT       = 30 * time.Second
+	DEFAULT_IDLE_TIMEOUT        = 2 * time.Minute
+	DEFAULT_PRE_SHUTDOWN_DELAY  = 5 * time.Second
+	DEFAULT_SHUTDOWN_TIMEOUT    = 20 * time.Second
+)
+
+// ServerConfig holds the HTTP server settings both services share.
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"HTTP_READ_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT"`
+	PreShutdownDelay  time.Duration `yaml:"pre_shutdown_delay" env:"PRE_SHUTDOWN_DELAY"` // how long readiness reports down before draining
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`     // how long in-flight requests get to finish
+}
+
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		ReadHeaderTimeout: DEFAULT_READ_HEADER_TIMEOUT,
+		ReadTimeout:       DEFAULT_READ_TIMEOUT,
+		WriteTimeout:      DEFAULT_WRITE_TIMEOUT,
+		IdleTimeout:       DEFAULT_IDLE_TIMEOUT,
+		PreShutdownDelay:  DEFAULT_PRE_SHUTDOWN_DELAY,
+		ShutdownTimeout:   DEFAULT_SHUTDOWN_TIMEOUT,
+	}
+}
+
+func (c ServerConfig) Validate() error {
+	if c.ReadHeaderTimeout <= 0 || c.ReadTimeout <= 0 || c.WriteTimeout <= 0 || c.IdleTimeout <= 0 || c.ShutdownTimeout <= 0 {
+		return errors.New("the HTTP_*_TIMEOUT and SHUTDOWN_TIMEOUT durations must be positive")
+	}
+	if c.PreShutdownDelay < 0 {
+		return errors.New("PRE_SHUTDOWN_DELAY must not be negative")
+	}
+	return nil
+}
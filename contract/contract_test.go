@@ -0,0 +1,111 @@
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata with the current encoding")
+
+// The golden files are the contract: a change to a JSON name or type fails here first,
+// and must be made deliberately with -update.
+func TestWireFormat(t *testing.T) {
+	flaggedAt := time.Date(2025, 3, 2, 10, 0, 0, 0, time.UTC)
+	bodies := map[string]interface{}{
+		"message":          Message{MessageID: 1, AuthorID: 2, Content: "hello", PubDate: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC), User: "alice"},
+		"user_details":     UserDetails{UserID: 2, Username: "alice", Email: "alice@example.com"},
+		"follows_response": FollowsResponse{Follows: []string{"bob"}, NextCursor: "next", PrevCursor: "prev"},
+		"register_request": RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "secret"},
+		"message_request":  MessageRequest{Content: "hello"},
+		"follow_request":   FollowRequest{Follow: "bob"},
+		"follow_response":  FollowResponse{Follow: "bob", Status: FOLLOW_STATUS_FOLLOWED},
+		"login_request":    LoginRequest{Username: "alice", Password: "secret"},
+		"login_response":   LoginResponse{Token: "token", ExpiresAt: 1740830400},
+		"latest_response":  LatestResponse{Latest: 7},
+		"flag_request":     FlagRequest{Reason: "spam"},
+		"flag_response":    FlagResponse{MessageID: 1, Flagged: true},
+		"flagged_message": FlaggedMessage{MessageID: 1, Content: "hello", PubDate: flaggedAt.Add(-time.Hour), User: "alice",
+			FlaggedBy: "admin", Reason: "spam", FlaggedAt: &flaggedAt},
+		"health_response": HealthResponse{Status: HEALTH_DOWN, Checks: map[string]HealthCheck{
+			"database": {Status: HEALTH_DOWN, LatencyMS: 1.5, Error: "timeout"}}},
+		"api_error": APIError{Status: 400, Code: ERR_VALIDATION, ErrorMsg: "You have to enter a username",
+			Fields: map[string]string{"username": "You have to enter a username"}, RequestID: "abc"},
+	}
+
+	for name, body := range bodies {
+		got, err := json.MarshalIndent(body, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, '\n')
+		path := filepath.Join("testdata", name+".json")
+		if *update {
+			if err := os.WriteFile(path, got, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v, run go test -update to create it", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s changed its wire format:\ngot  %s\nwant %s", name, got, want)
+		}
+	}
+}
+
+func TestValidation(t *testing.T) {
+	cases := []struct {
+		request interface{ Validate() error }
+		field   string
+	}{
+		{RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "pw"}, ""},
+		{RegisterRequest{Email: "alice@example.com", Password: "pw"}, "username"},
+		{RegisterRequest{Username: "alice", Email: "nope", Password: "pw"}, "email"},
+		{RegisterRequest{Username: "alice", Email: "alice@example.com"}, "pwd"},
+		{RegisterRequest{Username: strings.Repeat("a", MAX_USERNAME_LENGTH+1), Email: "alice@example.com", Password: "pw"}, "username"},
+		{RegisterRequest{Username: "alice", Email: strings.Repeat("a", MAX_EMAIL_LENGTH) + "@example.com", Password: "pw"}, "email"},
+		{RegisterRequest{Username: "alice", Email: "alice@example.com", Password: strings.Repeat("x", MAX_PASSWORD_BYTES)}, ""},
+		{RegisterRequest{Username: "alice", Email: "alice@example.com", Password: strings.Repeat("x", MAX_PASSWORD_BYTES+1)}, "pwd"},
+		{MessageRequest{Content: " "}, "content"},
+		{FollowRequest{}, "follow"},
+		{FollowRequest{Follow: "a", Unfollow: "b"}, "unfollow"},
+		{LoginRequest{Username: "alice"}, "password"},
+	}
+	for _, c := range cases {
+		err := c.request.Validate()
+		var validation *ValidationError
+		if c.field == "" && err != nil {
+			t.Errorf("%+v: unexpected error %v", c.request, err)
+		}
+		if c.field != "" && (!errors.As(err, &validation) || validation.Fields[c.field] == "") {
+			t.Errorf("%+v: expected an error for %s, got %v", c.request, c.field, err)
+		}
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	cases := map[string]bool{
+		"0f3a9c":     true,
+		"req-1/2:3":  true,
+		"":           false,
+		"with space": false,
+		"tab\there":  false,
+		"naïve":      false,
+		strings.Repeat("a", MAX_REQUEST_ID_LENGTH):   true,
+		strings.Repeat("a", MAX_REQUEST_ID_LENGTH+1): false,
+	}
+	for id, want := range cases {
+		if got := ValidRequestID(id); got != want {
+			t.Errorf("%q: got %v, want %v", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package contract
+
+// Error codes are part of the API: clients switch on them, so they must not change. The
+// messages next to them are free text.
+const (
+	ERR_BAD_REQUEST         = "bad_request"
+	ERR_MALFORMED_BODY      = "malformed_body"
+	ERR_BODY_TOO_LARGE      = "body_too_large"
+	ERR_VALIDATION          = "validation_failed"
+	ERR_UNAUTHORIZED        = "unauthorized"
+	ERR_FORBIDDEN           = "forbidden"
+	ERR_NOT_FOUND           = "not_found"
+	ERR_METHOD_NOT_ALLOWED  = "method_not_allowed"
+	ERR_USER_NOT_FOUND      = "user_not_found"
+	ERR_MESSAGE_NOT_FOUND   = "message_not_found"
+	ERR_USERNAME_TAKEN      = "username_taken"
+	ERR_CANNOT_FOLLOW_SELF  = "cannot_follow_self"
+	ERR_INVALID_PASSWORD    = "invalid_password"
+	ERR_INVALID_CREDENTIALS = "invalid_credentials"
+	ERR_COMMAND_CONFLICT    = "command_conflict"
+	ERR_INTERNAL            = "internal_error"
+)
+
+// APIError is the body of every failed request. Fields maps request fields to what is
+// wrong with them, RequestID matches the X-Request-ID response header.
+type APIError struct {
+	Status    int               `json:"status"`
+	Code      string            `json:"code"`
+	ErrorMsg  string            `json:"error_msg"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+func (e *APIError) Error() string { return e.Code + ": " + e.ErrorMsg }
@@ -0,0 +1,3 @@
+module devoops/contract
+
+go 1.23.6
@@ -0,0 +1,23 @@
+package contract
+
+// REQUEST_ID_HEADER carries the id that links a page request to the API calls made for it
+// and to the log lines about them.
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// MAX_REQUEST_ID_LENGTH keeps ids sent by clients from bloating every log line.
+const MAX_REQUEST_ID_LENGTH = 128
+
+// ValidRequestID accepts short ids of printable ASCII. The frontend and the API both
+// replace any other id with a generated one, so an id either service accepts is passed on
+// unchanged.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
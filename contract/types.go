@@ -0,0 +1,109 @@
+// Package contract is the wire format of the MiniTwit API: the request and response
+// bodies with their JSON names, the error codes and the validation the API applies. The
+// API and its clients both use it, so they cannot disagree on a field name.
+package contract
+
+import "time"
+
+// Message is a message as the API returns it. content, pub_date and user are the keys
+// the simulator expects, the ids let clients refer to a message. Times are RFC 3339 on
+// the wire.
+type Message struct {
+	MessageID uint      `json:"message_id"`
+	AuthorID  uint      `json:"author_id"`
+	Content   string    `json:"content"`
+	PubDate   time.Time `json:"pub_date"`
+	User      string    `json:"user"`
+}
+
+type UserDetails struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type FollowsResponse struct {
+	Follows    []string `json:"follows"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+	PrevCursor string   `json:"prev_cursor,omitempty"`
+}
+
+// Outcomes of a follow or unfollow request
+const (
+	FOLLOW_STATUS_FOLLOWED          = "followed"
+	FOLLOW_STATUS_ALREADY_FOLLOWING = "already-following"
+	FOLLOW_STATUS_UNFOLLOWED        = "unfollowed"
+	FOLLOW_STATUS_NOT_FOLLOWING     = "not-following"
+)
+
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"pwd"`
+}
+
+type MessageRequest struct {
+	Content string `json:"content"`
+}
+
+// FollowRequest carries either follow or unfollow, naming the other user.
+type FollowRequest struct {
+	Follow   string `json:"follow"`
+	Unfollow string `json:"unfollow"`
+}
+
+type FollowResponse struct {
+	Follow   string `json:"follow,omitempty"`
+	Unfollow string `json:"unfollow,omitempty"`
+	Status   string `json:"status"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type LoginResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+type LatestResponse struct {
+	Latest int `json:"latest"`
+}
+
+type FlagRequest struct {
+	Reason string `json:"reason"`
+}
+
+type FlagResponse struct {
+	MessageID uint `json:"message_id"`
+	Flagged   bool `json:"flagged"`
+}
+
+// FlaggedMessage is a flagged message with the latest flag recorded for it.
+type FlaggedMessage struct {
+	MessageID uint       `json:"message_id"`
+	Content   string     `json:"content"`
+	PubDate   time.Time  `json:"pub_date"`
+	User      string     `json:"user"`
+	FlaggedBy string     `json:"flagged_by"`
+	Reason    string     `json:"reason"`
+	FlaggedAt *time.Time `json:"flagged_at,omitempty"`
+}
+
+const (
+	HEALTH_OK   = "ok"
+	HEALTH_DOWN = "down"
+)
+
+type HealthCheck struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
@@ -0,0 +1,79 @@
+package contract
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Limits on registration fields. Passwords are capped at what bcrypt hashes without
+// truncating, the email length is the longest address SMTP allows.
+const (
+	MAX_USERNAME_LENGTH = 64
+	MAX_EMAIL_LENGTH    = 254
+	MAX_PASSWORD_BYTES  = 72
+)
+
+// ValidationError lists the invalid fields of a request. Its message is the first problem
+// found, so clients that only show one error show the most relevant one.
+type ValidationError struct {
+	Message string
+	Fields  map[string]string
+}
+
+func (e *ValidationError) Error() string { return e.Message }
+
+// check records message for field unless ok.
+func (e *ValidationError) check(ok bool, field, message string) {
+	if ok {
+		return
+	}
+	if e.Fields == nil {
+		e.Fields = map[string]string{}
+	}
+	if _, found := e.Fields[field]; !found {
+		e.Fields[field] = message
+	}
+	if e.Message == "" {
+		e.Message = message
+	}
+}
+
+// orNil returns the error if any check failed.
+func (e *ValidationError) orNil() error {
+	if e.Message == "" {
+		return nil
+	}
+	return e
+}
+
+func (req RegisterRequest) Validate() error {
+	var v ValidationError
+	v.check(req.Username != "", "username", "You have to enter a username")
+	v.check(len(req.Username) <= MAX_USERNAME_LENGTH, "username", fmt.Sprintf("The username must be at most %d characters", MAX_USERNAME_LENGTH))
+	v.check(req.Email != "", "email", "You have to enter a valid email address")
+	v.check(strings.Contains(req.Email, "@"), "email", "You have to enter a valid email address")
+	v.check(len(req.Email) <= MAX_EMAIL_LENGTH, "email", fmt.Sprintf("The email address must be at most %d characters", MAX_EMAIL_LENGTH))
+	v.check(req.Password != "", "pwd", "You have to enter a password")
+	v.check(len(req.Password) <= MAX_PASSWORD_BYTES, "pwd", fmt.Sprintf("The password must be at most %d bytes", MAX_PASSWORD_BYTES))
+	return v.orNil()
+}
+
+func (req MessageRequest) Validate() error {
+	var v ValidationError
+	v.check(strings.TrimSpace(req.Content) != "", "content", "You have to enter a message")
+	return v.orNil()
+}
+
+func (req FollowRequest) Validate() error {
+	var v ValidationError
+	v.check(req.Follow != "" || req.Unfollow != "", "follow", "Either follow or unfollow is required")
+	v.check(req.Follow == "" || req.Unfollow == "", "unfollow", "follow and unfollow cannot be combined")
+	return v.orNil()
+}
+
+func (req LoginRequest) Validate() error {
+	var v ValidationError
+	v.check(req.Username != "", "username", "You have to enter a username")
+	v.check(req.Password != "", "password", "You have to enter a password")
+	return v.orNil()
+}
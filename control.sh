@@ -13,9 +13,12 @@ elif [ $1 = "stop" ]; then
     echo "Stopping minitwit..."
     pkill -f minitwit
 elif [ $1 = "inspectdb" ]; then
-    ./flag_tool -i | less
+    (cd itu-minitwit-api && go run . admin flagged) | less
 elif [ $1 = "flag" ]; then
-    ./flag_tool "$@"
+    shift
+    for id in "$@"; do
+        (cd itu-minitwit-api && go run . admin flag "$id")
+    done
 else
   echo "I do not know this command..."
 fi
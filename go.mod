@@ -1,3 +1,13 @@
 module devoops
 
 go 1.23.6
+
+require (
+	devoops/client v0.0.0
+	devoops/contract v0.0.0 // indirect
+)
+
+replace (
+	devoops/client => ./client
+	devoops/contract => ./contract
+)
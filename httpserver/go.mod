@@ -0,0 +1,9 @@
+module devoops/httpserver
+
+go 1.23.6
+
+require devoops/config v0.0.0
+
+require gopkg.in/yaml.v3 v3.0.1 // indirect
+
+replace devoops/config => ../config
@@ -0,0 +1,74 @@
+// Package httpserver runs the HTTP server of the MiniTwit services and drains it on
+// shutdown, with the timeouts of config.ServerConfig.
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"devoops/config"
+)
+
+// Server serves HTTP until it is told to stop, and then drains the requests in flight.
+// Readiness checks use Draining to report down while that happens.
+type Server struct {
+	config   config.ServerConfig
+	draining atomic.Bool
+	// Logf reports the shutdown, log.Printf unless set.
+	Logf func(format string, args ...interface{})
+}
+
+func New(cfg config.ServerConfig) *Server {
+	return &Server{config: cfg, Logf: log.Printf}
+}
+
+// Draining reports whether shutdown has started.
+func (s *Server) Draining() bool { return s.draining.Load() }
+
+// Serve serves handler on listener until ctx is done. It then marks the server as
+// draining and keeps serving for PreShutdownDelay, so readiness probes see it go down and
+// traffic moves elsewhere. Only then it stops accepting connections and gives in-flight
+// requests ShutdownTimeout to finish.
+func (s *Server) Serve(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: s.config.ReadHeaderTimeout,
+		ReadTimeout:       s.config.ReadTimeout,
+		WriteTimeout:      s.config.WriteTimeout,
+		IdleTimeout:       s.config.IdleTimeout,
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.draining.Store(true)
+	s.Logf("Shutting down, reporting not ready for %s before draining", s.config.PreShutdownDelay)
+	select {
+	case err := <-served:
+		return err
+	case <-time.After(s.config.PreShutdownDelay):
+	}
+
+	s.Logf("Draining in-flight requests for up to %s", s.config.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("requests still in flight after %s: %w", s.config.ShutdownTimeout, err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	s.Logf("Server stopped")
+	return nil
+}
@@ -0,0 +1,64 @@
+package httpserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"devoops/config"
+)
+
+func TestServerIsNotReadyBeforeDraining(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultServerConfig()
+	cfg.PreShutdownDelay = 300 * time.Millisecond
+	server := New(cfg)
+	server.Logf = t.Logf
+
+	readyz := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if server.Draining() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- server.Serve(ctx, listener, readyz) }()
+
+	probe := func() int {
+		t.Helper()
+		resp, err := http.Get("http://" + listener.Addr().String() + "/readyz")
+		if err != nil {
+			t.Fatalf("probe during the pre-shutdown delay: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := probe(); status != http.StatusOK {
+		t.Fatalf("before shutdown: got status %d", status)
+	}
+
+	cancel()
+	for !server.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	if status := probe(); status != http.StatusServiceUnavailable {
+		t.Fatalf("during the pre-shutdown delay: got status %d", status)
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("stopped before the pre-shutdown delay passed: %v", err)
+	default:
+	}
+
+	if err := <-stopped; err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if _, err := http.Get("http://" + listener.Addr().String() + "/readyz"); err == nil {
+		t.Fatal("still accepting connections after shutdown")
+	}
+}
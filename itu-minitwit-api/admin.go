@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ADMIN_USAGE = `usage: minitwit-api admin [flags] command
+
+commands:
+  flag <message_id> [reason]     hide a message from all timelines
+  unflag <message_id> [reason]   show a flagged message again
+  flagged                        list flagged messages
+
+flags:`
+
+// adminClient calls the moderation routes of a running API.
+type adminClient struct {
+	url      string
+	user     string
+	password string
+	http     *http.Client
+}
+
+func (c *adminClient) do(method, path string, body interface{}) ([]byte, http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(c.url, "/")+path, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.SetBasicAuth(c.user, c.password)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		var apiErr APIError
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Code != "" {
+			return nil, nil, fmt.Errorf("%s %s: %s: %s (%s)", method, path, res.Status, apiErr.ErrorMsg, apiErr.Code)
+		}
+		return nil, nil, fmt.Errorf("%s %s: %s: %s", method, path, res.Status, strings.TrimSpace(string(data)))
+	}
+	return data, res.Header, nil
+}
+
+func (c *adminClient) setFlag(action string, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("%s needs a message id", action)
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid message id %q", args[0])
+	}
+	reason := strings.Join(args[1:], " ")
+	_, _, err = c.do(http.MethodPost, fmt.Sprintf("/admin/msgs/%d/%s", id, action), FlagRequest{Reason: reason})
+	if err != nil {
+		return err
+	}
+	if action == FLAG_ACTION_FLAG {
+		fmt.Printf("Flagged message %d\n", id)
+	} else {
+		fmt.Printf("Unflagged message %d\n", id)
+	}
+	return nil
+}
+
+func (c *adminClient) listFlagged() error {
+	path := "/admin/msgs/flagged"
+	for {
+		data, header, err := c.do(http.MethodGet, path, nil)
+		if err != nil {
+			return err
+		}
+		var messages []FlaggedMessage
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return err
+		}
+		for _, m := range messages {
+			flagged := "unknown"
+			if m.FlaggedAt != nil {
+				flagged = fmt.Sprintf("%s by %s", m.FlaggedAt.Format(time.RFC3339), m.FlaggedBy)
+			}
+			fmt.Printf("%d\t%s\t%s\t%q\treason: %q\n", m.MessageID, m.User, flagged, m.Content, m.Reason)
+		}
+		next := header.Get("X-Next-Cursor")
+		if next == "" {
+			return nil
+		}
+		path = "/admin/msgs/flagged?before=" + next
+	}
+}
+
+// runAdminCommand implements the admin subcommand, with flags overriding the admin
+// configuration. The credential must be listed in the ADMIN_USERS of the API it talks to.
+func runAdminCommand(cfg Config, args []string) error {
+	flags := flag.NewFlagSet("admin", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), ADMIN_USAGE)
+		flags.PrintDefaults()
+	}
+	url := cfg.Admin.URL
+	if url == "" {
+		url = "http://localhost" + cfg.Port
+	}
+	client := &adminClient{http: &http.Client{Timeout: 10 * time.Second}}
+	flags.StringVar(&client.url, "url", url, "API base URL (ADMIN_API_URL)")
+	flags.StringVar(&client.user, "user", cfg.Admin.User, "admin username (ADMIN_USER)")
+	flags.StringVar(&client.password, "password", cfg.Admin.Password, "admin password (ADMIN_PASSWORD)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if client.user == "" || client.password == "" {
+		return errors.New("admin credentials are missing, set ADMIN_USER and ADMIN_PASSWORD")
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		return errors.New("missing command")
+	}
+	switch args[0] {
+	case "flag":
+		return client.setFlag(FLAG_ACTION_FLAG, args[1:])
+	case "unflag":
+		return client.setFlag(FLAG_ACTION_UNFLAG, args[1:])
+	case "flagged":
+		return client.listFlagged()
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
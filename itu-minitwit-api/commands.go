@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const DEFAULT_COMMAND_RETENTION = 24 * time.Hour
+
+// Mutating simulator routes, keyed by method and path template. Each ?latest= id is
+// applied once, retries get the recorded response back.
+var commandRoutes = map[string]bool{
+	"POST /register":         true,
+	"POST /msgs/{username}":  true,
+	"POST /fllws/{username}": true,
+}
+
+// ProcessedCommand is the response recorded for a simulator command. Status is 0 while
+// the command is being applied, BodyHash is the hex SHA-256 of the request body.
+type ProcessedCommand struct {
+	Latest      int64     `gorm:"primaryKey;autoIncrement:false"`
+	Method      string    `gorm:"not null"`
+	Path        string    `gorm:"not null"`
+	BodyHash    string    `gorm:"not null;default:''"`
+	Status      int       `gorm:"not null"`
+	ContentType string    `gorm:"not null;default:''"`
+	Body        string    `gorm:"not null;default:''"`
+	CreatedAt   time.Time `gorm:"index:idx_processed_commands_created_at"`
+}
+
+// CommandLog records processed simulator commands for the retention window.
+type CommandLog struct {
+	retention time.Duration
+}
+
+// newCommandLog keeps commands for retention. Retries arriving later than that are
+// applied again.
+func newCommandLog(retention time.Duration) *CommandLog {
+	return &CommandLog{retention: retention}
+}
+
+// PruneEvery deletes the commands recorded before the retention window in the background,
+// a few times per window.
+func (c *CommandLog) PruneEvery(store SimulatorStore) {
+	interval := c.retention / 4
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	go func() {
+		for range time.Tick(interval) {
+			pruned, err := store.PruneCommands(time.Now().UTC().Add(-c.retention))
+			if err != nil {
+				logger.WithError(err).Error("Failed to prune processed commands")
+			} else if pruned > 0 {
+				logger.WithField("commands", pruned).Info("Pruned processed commands")
+			}
+		}
+	}()
+}
+
+// recordingWriter keeps a copy of the response so it can be replayed.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Status() int { return w.status }
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// IdempotencyMiddleware applies every simulator command once. The id is claimed before the
+// handler runs, so concurrent retries cannot both apply it, and released again when the
+// handler fails with a server error or panics so the simulator can retry.
+func (api *API) IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		latest, err := strconv.ParseInt(r.URL.Query().Get("latest"), 10, 64)
+		if err != nil || !commandRoutes[r.Method+" "+routeTemplate(r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_REQUEST_BODY))
+		if err != nil {
+			apiErr := requestError(err)
+			if apiErr == nil {
+				apiErr = newAPIError(http.StatusBadRequest, ERR_BAD_REQUEST, "Failed to read request body")
+			}
+			writeError(w, r, apiErr)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+
+		claim := ProcessedCommand{
+			Latest:    latest,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			BodyHash:  hex.EncodeToString(sum[:]),
+			CreatedAt: time.Now().UTC(),
+		}
+		claimed, err := api.store.ClaimCommand(claim)
+		if err != nil {
+			requestLogger(r).WithError(err).Error("Failed to record simulator command")
+			writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Failed to record command"))
+			return
+		}
+		if !claimed {
+			api.replayCommand(w, r, claim)
+			return
+		}
+
+		defer func() {
+			if p := recover(); p != nil {
+				if err := api.store.ReleaseCommand(latest); err != nil {
+					requestLogger(r).WithError(err).WithField("latest", latest).Error("Failed to release simulator command")
+				}
+				panic(p)
+			}
+		}()
+
+		recorder := &recordingWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		if recorder.status >= 500 {
+			err = api.store.ReleaseCommand(latest)
+		} else {
+			err = api.store.CompleteCommand(latest, recorder.status, recorder.Header().Get("Content-Type"), recorder.body.String())
+		}
+		if err != nil {
+			requestLogger(r).WithError(err).WithField("latest", latest).Error("Failed to store simulator command response")
+		}
+	})
+}
+
+// replayCommand answers a command whose id is already claimed, with the recorded response
+// if request is a retry of it.
+func (api *API) replayCommand(w http.ResponseWriter, r *http.Request, request ProcessedCommand) {
+	latest := request.Latest
+	command, err := api.store.GetCommand(latest)
+	if err != nil {
+		requestLogger(r).WithError(err).Error("Failed to load simulator command")
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Failed to load command"))
+		return
+	}
+
+	fields := logrus.Fields{"latest": latest, "method": r.Method, "path": r.URL.Path}
+	switch {
+	case command.Method != request.Method || command.Path != request.Path:
+		requestLogger(r).WithFields(fields).Warn("Command id reused for a different request")
+		api.metrics.BadRequests.WithLabelValues("command_conflict").Inc()
+		writeError(w, r, newAPIError(http.StatusConflict, ERR_COMMAND_CONFLICT, fmt.Sprintf("Command %d was already used for %s %s", latest, command.Method, command.Path)))
+	case command.BodyHash != "" && command.BodyHash != request.BodyHash:
+		// Commands recorded before the hash was stored have none and are replayed
+		requestLogger(r).WithFields(fields).Warn("Command id reused with a different body")
+		api.metrics.BadRequests.WithLabelValues("command_conflict").Inc()
+		writeError(w, r, newAPIError(http.StatusConflict, ERR_COMMAND_CONFLICT, fmt.Sprintf("Command %d was already used with a different body", latest)))
+	case command.Status == 0:
+		requestLogger(r).WithFields(fields).Warn("Command retried while still being processed")
+		api.metrics.BadRequests.WithLabelValues("command_conflict").Inc()
+		writeError(w, r, newAPIError(http.StatusConflict, ERR_COMMAND_CONFLICT, fmt.Sprintf("Command %d is still being processed", latest)))
+	default:
+		requestLogger(r).WithFields(fields).Info("Replaying simulator command")
+		api.metrics.SuccessfulRequests.WithLabelValues("replayed_command").Inc()
+		if command.ContentType != "" {
+			w.Header().Set("Content-Type", command.ContentType)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(command.Status)
+		if command.Status == http.StatusNoContent || command.Status == http.StatusNotModified {
+			return
+		}
+		_, err = w.Write([]byte(command.Body))
+		if err != nil {
+			requestLogger(r).WithError(err).Error("Failed to write replayed response")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRetriedCommandIsReplayed(t *testing.T) {
+	server := newTestServer(t)
+	register(t, server, "alice")
+
+	first := simulate(t, server, "POST", "/msgs/alice?latest=7", `{"content":"once"}`)
+	expectStatus(t, first, http.StatusNoContent)
+	retry := simulate(t, server, "POST", "/msgs/alice?latest=7", `{"content":"once"}`)
+	expectStatus(t, retry, http.StatusNoContent)
+	if retry.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatal("retry was not replayed")
+	}
+	expectStatus(t, simulate(t, server, "POST", "/fllws/alice?latest=7", `{"follow":"alice"}`), http.StatusConflict)
+
+	var messages []MessageResponse
+	decode(t, simulate(t, server, "GET", "/msgs/alice", ""), &messages)
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want the command applied once", len(messages))
+	}
+}
+
+func TestReusedCommandIdWithDifferentBodyConflicts(t *testing.T) {
+	server := newTestServer(t)
+	register(t, server, "alice")
+
+	expectStatus(t, simulate(t, server, "POST", "/msgs/alice?latest=7", `{"content":"first run"}`), http.StatusNoContent)
+	resp := simulate(t, server, "POST", "/msgs/alice?latest=7", `{"content":"second run"}`)
+	expectStatus(t, resp, http.StatusConflict)
+	var response APIError
+	decode(t, resp, &response)
+	if response.Code != ERR_COMMAND_CONFLICT {
+		t.Fatalf("unexpected error response %+v", response)
+	}
+
+	var messages []MessageResponse
+	decode(t, simulate(t, server, "GET", "/msgs/alice", ""), &messages)
+	if len(messages) != 1 || messages[0].Content != "first run" {
+		t.Fatalf("got messages %+v, want only the first run's", messages)
+	}
+}
+
+func TestCommandIdsAreNotReusedAcrossRequests(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, simulate(t, server, "POST", "/register?latest=1", `{"username":"alice","email":"a@a","pwd":"pw"}`), http.StatusNoContent)
+	resp := simulate(t, server, "POST", "/register?latest=2", `{"username":"","email":"a@a","pwd":"pw"}`)
+	expectStatus(t, resp, http.StatusBadRequest)
+	var response APIError
+	decode(t, resp, &response)
+	if response.ErrorMsg != "You have to enter a username" {
+		t.Fatalf("unexpected error response %+v", response)
+	}
+}
+
+func TestPanickingCommandIsReleased(t *testing.T) {
+	api := newTestAPI(t)
+	router := mux.NewRouter()
+	router.Use(api.IdempotencyMiddleware)
+	router.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}).Methods("POST")
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("the panic was swallowed")
+			}
+		}()
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/register?latest=4", nil))
+	}()
+
+	if _, err := api.store.GetCommand(4); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("command is still claimed: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"devoops/config"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config is everything the API reads at startup. Settings start at defaultConfig, can be
+// set in the YAML file named by --config or CONFIG_FILE, and are overridden by their
+// environment variable.
+type Config struct {
+	Mode             string              `yaml:"mode" env:"MODE"` // production or dev
+	Port             string              `yaml:"port" env:"PORT"`
+	LogLevel         string              `yaml:"log_level" env:"LOG_LEVEL"`
+	PageSize         int                 `yaml:"page_size" env:"PAGE_SIZE"` // timeline and search page size
+	CommandRetention time.Duration       `yaml:"command_retention" env:"COMMAND_RETENTION"`
+	Database         DatabaseConfig      `yaml:"database"`
+	Server           config.ServerConfig `yaml:"server"`
+	Auth             AuthConfig          `yaml:"auth"`
+	Admin            AdminConfig         `yaml:"admin"`
+	Passwords        PasswordConfig      `yaml:"passwords"`
+	Tokens           TokenConfig         `yaml:"tokens"`
+}
+
+// DatabaseConfig selects PostgreSQL when Host is set and the SQLite file at Path otherwise.
+type DatabaseConfig struct {
+	Path        string `yaml:"path" env:"DATABASE"`
+	Host        string `yaml:"host" env:"DB_HOST"`
+	Port        string `yaml:"port" env:"DB_PORT"`
+	User        string `yaml:"user" env:"DB_USER"`
+	Password    string `yaml:"password" env:"DB_PASSWORD" secret:"true"`
+	Name        string `yaml:"name" env:"DB_NAME"`
+	AutoMigrate bool   `yaml:"auto_migrate" env:"AUTO_MIGRATE"` // false when migrations run with the migrate subcommand
+	// LegacyLatestFile is the text file the simulator_latest migration imports the latest
+	// action id from.
+	LegacyLatestFile string `yaml:"legacy_latest_file" env:"LATEST_FILE"`
+}
+
+type AuthConfig struct {
+	SimulatorUser     string `yaml:"simulator_user" env:"SIMULATOR_USER"`
+	SimulatorPassword string `yaml:"simulator_password" env:"SIMULATOR_PASSWORD" secret:"true"`
+	ServiceToken      string `yaml:"service_token" env:"SERVICE_TOKEN" secret:"true"`
+	AdminUsers        string `yaml:"admin_users" env:"ADMIN_USERS" secret:"true"` // comma separated username:password pairs
+}
+
+// AdminConfig is the credential and API the admin subcommand uses. Without a URL it calls
+// the API on Port of this host.
+type AdminConfig struct {
+	URL      string `yaml:"url" env:"ADMIN_API_URL"`
+	User     string `yaml:"user" env:"ADMIN_USER"`
+	Password string `yaml:"password" env:"ADMIN_PASSWORD" secret:"true"`
+}
+
+// PasswordConfig selects the hasher for new passwords, bcrypt or argon2id, and its cost.
+type PasswordConfig struct {
+	Hasher        string `yaml:"hasher" env:"PASSWORD_HASHER"`
+	BcryptCost    int    `yaml:"bcrypt_cost" env:"BCRYPT_COST"`
+	Argon2Time    int    `yaml:"argon2_time" env:"ARGON2_TIME"`
+	Argon2Memory  int    `yaml:"argon2_memory" env:"ARGON2_MEMORY"` // KiB
+	Argon2Threads int    `yaml:"argon2_threads" env:"ARGON2_THREADS"`
+}
+
+// TokenConfig configures the user tokens. The secret is required outside dev mode; without
+// one a random secret is generated, so tokens do not survive a restart.
+type TokenConfig struct {
+	Secret string        `yaml:"secret" env:"TOKEN_SECRET" secret:"true"`
+	TTL    time.Duration `yaml:"ttl" env:"TOKEN_TTL"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Mode:             config.MODE_PRODUCTION,
+		Port:             ":9090",
+		LogLevel:         "info",
+		PageSize:         PER_PAGE,
+		CommandRetention: DEFAULT_COMMAND_RETENTION,
+		Database:         DatabaseConfig{Path: "../minitwit.db", AutoMigrate: true, LegacyLatestFile: LEGACY_LATEST_FILE},
+		Server:           config.DefaultServerConfig(),
+		Auth:             AuthConfig{SimulatorUser: "simulator", SimulatorPassword: "super_safe!"},
+		Passwords:        PasswordConfig{Hasher: "bcrypt", BcryptCost: bcrypt.DefaultCost, Argon2Time: 1, Argon2Memory: 64 * 1024, Argon2Threads: 4},
+		Tokens:           TokenConfig{TTL: DEFAULT_TOKEN_TTL},
+	}
+}
+
+// loadConfig reads the configuration and checks it, reporting every invalid setting.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	if err := errors.Join(config.Load(path, &cfg), cfg.Validate()); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Mode == config.MODE_PRODUCTION || c.Mode == config.MODE_DEV,
+		"MODE must be %s or %s, got %q", config.MODE_PRODUCTION, config.MODE_DEV, c.Mode)
+	_, _, err := net.SplitHostPort(c.Port)
+	check(err == nil, "PORT must be an address such as :9090, got %q", c.Port)
+	_, err = logrus.ParseLevel(c.LogLevel)
+	check(err == nil, "LOG_LEVEL %q is not a log level", c.LogLevel)
+	check(c.PageSize > 0, "PAGE_SIZE must be positive")
+	check(c.CommandRetention > 0, "COMMAND_RETENTION must be positive")
+
+	if c.Database.Host != "" {
+		check(c.Database.Port != "" && c.Database.User != "" && c.Database.Name != "",
+			"DB_PORT, DB_USER and DB_NAME are required with DB_HOST")
+	} else {
+		check(c.Database.Path != "", "DATABASE must name the SQLite file when DB_HOST is not set")
+	}
+
+	if err := c.Server.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	check(c.Auth.SimulatorUser != "" && c.Auth.SimulatorPassword != "", "SIMULATOR_USER and SIMULATOR_PASSWORD must not be empty")
+	check(c.Mode == config.MODE_DEV || c.Auth.ServiceToken != "", "SERVICE_TOKEN is required outside dev mode")
+	for _, pair := range strings.Split(c.Auth.AdminUsers, ",") {
+		if pair = strings.TrimSpace(pair); pair != "" {
+			name, password, found := strings.Cut(pair, ":")
+			check(found && name != "" && password != "", "ADMIN_USERS entries must be username:password")
+		}
+	}
+
+	p := c.Passwords
+	switch p.Hasher {
+	case "bcrypt":
+		check(p.BcryptCost >= bcrypt.MinCost && p.BcryptCost <= bcrypt.MaxCost,
+			"BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	case "argon2id":
+		check(p.Argon2Time >= 1 && p.Argon2Memory >= 8 && p.Argon2Threads >= 1 && p.Argon2Threads <= 255,
+			"invalid argon2id parameters")
+	default:
+		errs = append(errs, fmt.Errorf("unknown PASSWORD_HASHER %q", p.Hasher))
+	}
+
+	check(c.Mode == config.MODE_DEV || c.Tokens.Secret != "", "TOKEN_SECRET is required outside dev mode")
+	check(c.Tokens.TTL > 0, "TOKEN_TTL must be positive")
+	return errors.Join(errs...)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"devoops/contract"
+)
+
+// Error codes are part of the API and defined in the contract module.
+const (
+	ERR_BAD_REQUEST         = contract.ERR_BAD_REQUEST
+	ERR_MALFORMED_BODY      = contract.ERR_MALFORMED_BODY
+	ERR_BODY_TOO_LARGE      = contract.ERR_BODY_TOO_LARGE
+	ERR_VALIDATION          = contract.ERR_VALIDATION
+	ERR_UNAUTHORIZED        = contract.ERR_UNAUTHORIZED
+	ERR_FORBIDDEN           = contract.ERR_FORBIDDEN
+	ERR_NOT_FOUND           = contract.ERR_NOT_FOUND
+	ERR_METHOD_NOT_ALLOWED  = contract.ERR_METHOD_NOT_ALLOWED
+	ERR_USER_NOT_FOUND      = contract.ERR_USER_NOT_FOUND
+	ERR_MESSAGE_NOT_FOUND   = contract.ERR_MESSAGE_NOT_FOUND
+	ERR_USERNAME_TAKEN      = contract.ERR_USERNAME_TAKEN
+	ERR_CANNOT_FOLLOW_SELF  = contract.ERR_CANNOT_FOLLOW_SELF
+	ERR_INVALID_PASSWORD    = contract.ERR_INVALID_PASSWORD
+	ERR_INVALID_CREDENTIALS = contract.ERR_INVALID_CREDENTIALS
+	ERR_COMMAND_CONFLICT    = contract.ERR_COMMAND_CONFLICT
+	ERR_INTERNAL            = contract.ERR_INTERNAL
+)
+
+// APIError is the body of every failed request.
+type APIError = contract.APIError
+
+func newAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, ErrorMsg: message}
+}
+
+// writeError writes err as an APIError. Errors that are not an APIError or a request
+// decoding error are reported as internal errors without exposing their text.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var response *APIError
+	if !errors.As(err, &response) {
+		response = requestError(err)
+	}
+	if response == nil {
+		requestLogger(r).WithError(err).WithField("path", r.URL.Path).Error("Unhandled error")
+		response = newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Internal server error")
+	}
+	body := *response
+	body.RequestID = requestID(r)
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	CheckEncodeResponse(w, body, body.Status)
+}
+
+// notFoundHandler and methodNotAllowedHandler replace the plain text responses of mux,
+// which does not run middleware for them.
+func notFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, newAPIError(http.StatusNotFound, ERR_NOT_FOUND, "No such endpoint"))
+	})
+}
+
+func methodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, ERR_METHOD_NOT_ALLOWED, "Method not allowed"))
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"devoops/contract"
+)
+
+// HEALTH_CHECK_TIMEOUT bounds each readiness check, so a hanging dependency reports as
+// down instead of hanging the probe.
+const HEALTH_CHECK_TIMEOUT = 2 * time.Second
+
+const (
+	HEALTH_OK   = contract.HEALTH_OK
+	HEALTH_DOWN = contract.HEALTH_DOWN
+)
+
+var errShuttingDown = errors.New("shutting down")
+
+// runHealthChecks runs every check and answers 503 if any of them fails.
+func runHealthChecks(w http.ResponseWriter, r *http.Request, checks map[string]func(context.Context) error) {
+	response := HealthResponse{Status: HEALTH_OK, Checks: map[string]HealthCheck{}}
+	for name, check := range checks {
+		ctx, cancel := context.WithTimeout(r.Context(), HEALTH_CHECK_TIMEOUT)
+		start := time.Now()
+		err := check(ctx)
+		cancel()
+
+		result := HealthCheck{Status: HEALTH_OK, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+		if err != nil {
+			requestLogger(r).WithError(err).WithField("check", name).Warn("Readiness check failed")
+			result.Status, result.Error = HEALTH_DOWN, err.Error()
+			response.Status = HEALTH_DOWN
+		}
+		response.Checks[name] = result
+	}
+
+	status := http.StatusOK
+	if response.Status != HEALTH_OK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	CheckEncodeResponse(w, response, status)
+}
+
+// GETHealthHandler answers as long as the process serves requests.
+func (api *API) GETHealthHandler(w http.ResponseWriter, r *http.Request) {
+	runHealthChecks(w, r, nil)
+}
+
+// GETReadyHandler answers 200 once the database can be reached, and 503 again while the
+// server drains on shutdown.
+func (api *API) GETReadyHandler(w http.ResponseWriter, r *http.Request) {
+	runHealthChecks(w, r, map[string]func(context.Context) error{
+		"database": api.store.Ping,
+		"server":   api.serving,
+	})
+}
+
+func (api *API) serving(context.Context) error {
+	if api.server.Draining() {
+		return errShuttingDown
+	}
+	return nil
+}
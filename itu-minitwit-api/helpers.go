@@ -1,11 +1,10 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
-	//"os"
+
 	"gorm.io/gorm"
 )
 
@@ -15,27 +14,27 @@ func Error() string {
 	return "An error occurred."
 }
 
-// getUserID retrieves the user_id for a given username.
-func (api API) getUserID(db *gorm.DB, username string) (uint, error) {
-	var user User
-	result := db.Select("user_id").Where("username = ?", username).First(&user)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			// dummy workaround to get rid of errors caused by old api downtime
+// getUserID retrieves the user_id for a given username, 0 if there is no such user.
+func (api *API) getUserID(username string) (uint, error) {
+	user, err := api.store.GetUserByUsername(username)
+	if errors.Is(err, ErrNotFound) {
+		// dummy workaround to get rid of errors caused by old api downtime
 
-			//return createDummyUser(api, username)
+		//return createDummyUser(api, username)
 
-			api.metrics.UserNotFound.WithLabelValues("Users_not_found").Inc()
-			return 0, nil // user not found
-		}
-		return 0, result.Error
+		api.metrics.UserNotFound.WithLabelValues("Users_not_found").Inc()
+		return 0, nil // user not found
+	}
+	if err != nil {
+		return 0, err
 	}
 	return user.UserID, nil
 }
 
-func initDB() {
+// initDB connects to the database and applies pending migrations.
+func initDB(cfg DatabaseConfig) *gorm.DB {
 	// Open database connection
-	db, err := connectDB()
+	db, err := connectDB(cfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -46,13 +45,21 @@ func initDB() {
 	// }
 	// defer sqlDB.Close()
 
-	// Auto-migrate the schema
-	err = db.AutoMigrate(&User{}, &Follower{}, &Message{})
+	// Apply pending migrations unless they are run separately with the migrate subcommand
+	if !cfg.AutoMigrate {
+		fmt.Println("Skipping migrations, AUTO_MIGRATE is false")
+		return db
+	}
+	migrator, err := NewMigrator(db, schemaMigrations(cfg))
 	if err != nil {
+		log.Fatalf("Failed to prepare migrations: %v", err)
+	}
+	if _, err := migrator.Up(); err != nil {
 		log.Fatalf("Failed to migrate database schema: %v", err)
 	}
 
 	fmt.Println("Database initialized successfully")
+	return db
 }
 
 // func fileExists(filename string) bool {
@@ -62,21 +69,3 @@ func initDB() {
 // 	}
 // 	return !info.IsDir()
 // }
-
-func NotReqFromSimulator(w http.ResponseWriter, r *http.Request) bool {
-	fromSimulator := r.Header.Get("Authorization")
-	if fromSimulator != "Basic c2ltdWxhdG9yOnN1cGVyX3NhZmUh" {
-		w.WriteHeader(http.StatusForbidden)
-		response := map[string]interface{}{
-			"status":    http.StatusForbidden,
-			"error_msg": "You are not authorized to use this resource!",
-		}
-		err := json.NewEncoder(w).Encode(response)
-		if err != nil {
-			fmt.Print(err.Error())
-			return false
-		}
-		return true
-	}
-	return false
-}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// LEGACY_LATEST_FILE is where the latest action id was kept before it moved to the database.
+const LEGACY_LATEST_FILE = "./latest_processed_sim_action_id.txt"
+
+// SimulatorLatest is the single row holding the latest action id the simulator sent.
+type SimulatorLatest struct {
+	ID     uint `gorm:"primaryKey;autoIncrement:false"`
+	Latest int  `gorm:"not null"`
+}
+
+func (SimulatorLatest) TableName() string { return "simulator_latest" }
+
+const SIMULATOR_LATEST_ROW = 1
+
+// readLegacyLatest returns the id stored in the old text file, or -1 without one.
+func readLegacyLatest(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return -1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "" {
+		return -1, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// createSimulatorLatest creates the table and imports the id from the text file at path once.
+func createSimulatorLatest(tx *gorm.DB, path string) error {
+	if err := tx.Migrator().CreateTable(&simulatorLatestV1{}); err != nil {
+		return err
+	}
+	latest, err := readLegacyLatest(path)
+	if err != nil {
+		logger.WithError(err).Warn("Could not import " + path + ", starting from -1")
+		latest = -1
+	}
+	return tx.Create(&simulatorLatestV1{ID: SIMULATOR_LATEST_ROW, Latest: latest}).Error
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLatestOnlyMovesForward(t *testing.T) {
+	server := newTestServer(t)
+
+	var latest map[string]int
+	decode(t, simulate(t, server, "GET", "/latest", ""), &latest)
+	if latest["latest"] != -1 {
+		t.Fatalf("got latest %d before any command, want -1", latest["latest"])
+	}
+
+	register(t, server, "alice")
+	expectStatus(t, simulate(t, server, "GET", "/msgs?latest=5", ""), http.StatusOK)
+	expectStatus(t, simulate(t, server, "GET", "/msgs?latest=3", ""), http.StatusOK)
+	decode(t, simulate(t, server, "GET", "/latest", ""), &latest)
+	if latest["latest"] != 5 {
+		t.Fatalf("got latest %d, want 5", latest["latest"])
+	}
+}
+
+func TestGormStoreLatestOnlyMovesForward(t *testing.T) {
+	store := newTestGormStore(t)
+	for _, id := range []int{5, 3, 5, 8} {
+		if err := store.StoreLatest(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	latest, err := store.LoadLatest()
+	if err != nil || latest != 8 {
+		t.Fatalf("got latest %d, %v, want 8", latest, err)
+	}
+}
+
+func TestReadLegacyLatest(t *testing.T) {
+	dir := t.TempDir()
+	if latest, err := readLegacyLatest(filepath.Join(dir, "missing.txt")); latest != -1 || err != nil {
+		t.Fatalf("missing file: got %d, %v", latest, err)
+	}
+
+	path := filepath.Join(dir, "latest.txt")
+	if err := os.WriteFile(path, []byte("42\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := readLegacyLatest(path); latest != 42 || err != nil {
+		t.Fatalf("got %d, %v, want 42", latest, err)
+	}
+}
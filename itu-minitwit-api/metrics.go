@@ -1,6 +1,14 @@
 package main
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
 
 type Metrics struct {
 	SuccessfulRequests *prometheus.CounterVec
@@ -9,8 +17,19 @@ type Metrics struct {
 	FollowRequests     *prometheus.CounterVec
 	BadRequests        *prometheus.CounterVec
 	UserNotFound       *prometheus.CounterVec
+
+	// Recorded for every request by MetricsMiddleware, labelled by route template
+	Requests         *prometheus.CounterVec
+	RequestDuration  *prometheus.HistogramVec
+	RequestsInFlight *prometheus.GaugeVec
+	// Recorded for every statement by the queryMetrics GORM plugin
+	QueryDuration *prometheus.HistogramVec
 }
 
+// UNMATCHED_ROUTE labels requests that matched no route, so unknown paths do not each
+// create a new series.
+const UNMATCHED_ROUTE = "unmatched"
+
 func InitMetrics() *Metrics {
 	m := &Metrics{
 		SuccessfulRequests: prometheus.NewCounterVec(
@@ -55,6 +74,36 @@ func InitMetrics() *Metrics {
 			},
 			[]string{"path"},
 		),
+		Requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_requests_total",
+				Help: "Total number of HTTP requests by route, method and status code",
+			},
+			[]string{"route", "method", "status"},
+		),
+		RequestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_request_duration_seconds",
+				Help:    "Time spent answering HTTP requests",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"route", "method"},
+		),
+		RequestsInFlight: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "http_requests_in_flight",
+				Help: "Number of HTTP requests currently being answered",
+			},
+			[]string{"route"},
+		),
+		QueryDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "db_query_duration_seconds",
+				Help:    "Time spent on database statements",
+				Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+			},
+			[]string{"operation", "table"},
+		),
 	}
 	prometheus.MustRegister(m.UserNotFound)
 	prometheus.MustRegister(m.SuccessfulRequests)
@@ -62,6 +111,81 @@ func InitMetrics() *Metrics {
 	prometheus.MustRegister(m.FollowRequests)
 	prometheus.MustRegister(m.UnfollowRequests)
 	prometheus.MustRegister(m.MessagesSent)
+	prometheus.MustRegister(m.Requests)
+	prometheus.MustRegister(m.RequestDuration)
+	prometheus.MustRegister(m.RequestsInFlight)
+	prometheus.MustRegister(m.QueryDuration)
 
 	return m
 }
+
+// MetricsMiddleware counts and times every request. It runs after routing, so requests
+// are labelled by route template rather than by path.
+func (m *Metrics) MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		if route == "" {
+			route = UNMATCHED_ROUTE
+		}
+		inFlight := m.RequestsInFlight.WithLabelValues(route)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		writer := trackResponse(w)
+		next.ServeHTTP(writer, r)
+
+		m.RequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		m.Requests.WithLabelValues(route, r.Method, strconv.Itoa(writer.sentStatus())).Inc()
+	})
+}
+
+// queryMetrics is a GORM plugin timing every statement by operation and table.
+type queryMetrics struct {
+	duration *prometheus.HistogramVec
+}
+
+const queryStartKey = "metrics:query_start"
+
+func newQueryMetrics(duration *prometheus.HistogramVec) *queryMetrics {
+	return &queryMetrics{duration: duration}
+}
+
+func (q *queryMetrics) Name() string { return "metrics" }
+
+func (q *queryMetrics) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("metrics:before_create", q.before),
+		callbacks.Create().After("gorm:create").Register("metrics:after_create", q.after("create")),
+		callbacks.Query().Before("gorm:query").Register("metrics:before_query", q.before),
+		callbacks.Query().After("gorm:query").Register("metrics:after_query", q.after("query")),
+		callbacks.Update().Before("gorm:update").Register("metrics:before_update", q.before),
+		callbacks.Update().After("gorm:update").Register("metrics:after_update", q.after("update")),
+		callbacks.Delete().Before("gorm:delete").Register("metrics:before_delete", q.before),
+		callbacks.Delete().After("gorm:delete").Register("metrics:after_delete", q.after("delete")),
+		callbacks.Row().Before("gorm:row").Register("metrics:before_row", q.before),
+		callbacks.Row().After("gorm:row").Register("metrics:after_row", q.after("row")),
+		callbacks.Raw().Before("gorm:raw").Register("metrics:before_raw", q.before),
+		callbacks.Raw().After("gorm:raw").Register("metrics:after_raw", q.after("raw")),
+	)
+}
+
+func (q *queryMetrics) before(db *gorm.DB) {
+	db.InstanceSet(queryStartKey, time.Now())
+}
+
+func (q *queryMetrics) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(queryStartKey)
+		start, isTime := value.(time.Time)
+		if !ok || !isTime {
+			return
+		}
+		table := db.Statement.Table
+		if table == "" {
+			table = "none"
+		}
+		q.duration.WithLabelValues(operation, table).Observe(time.Since(start).Seconds())
+	}
+}
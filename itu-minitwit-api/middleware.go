@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"devoops/contract"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// SERVICE_TOKEN_HEADER carries the shared secret the frontend uses for its calls to the API.
+const SERVICE_TOKEN_HEADER = "X-Service-Token"
+
+// REQUEST_ID_HEADER identifies a request in responses and logs. Ids sent by the client are
+// kept so a request can be followed across services.
+const REQUEST_ID_HEADER = contract.REQUEST_ID_HEADER
+
+// Routes the simulator talks to. The frontend uses some of them too, so they also accept
+// the service credential.
+var simulatorRoutes = map[string]bool{
+	"/latest":           true,
+	"/register":         true,
+	"/msgs":             true,
+	"/msgs/{username}":  true,
+	"/fllws/{username}": true,
+}
+
+// Routes that act on behalf of a user, keyed by method and path template. Requests from
+// the frontend must carry a token of that user, the simulator may act for anyone.
+var userRoutes = map[string]bool{
+	"POST /msgs/{username}":  true,
+	"POST /fllws/{username}": true,
+	"GET /followingmsgs":     true,
+}
+
+// Moderation routes, reserved for the admins in ADMIN_USERS.
+var adminRoutes = map[string]bool{
+	"/admin/msgs/flagged":            true,
+	"/admin/msgs/{id:[0-9]+}/flag":   true,
+	"/admin/msgs/{id:[0-9]+}/unflag": true,
+}
+
+// Routes that need no credential at all.
+var publicRoutes = map[string]bool{
+	"/metrics":                  true,
+	"/healthz":                  true,
+	"/readyz":                   true,
+	"/openapi.json":             true,
+	"/docs":                     true,
+	"/docs/redoc.standalone.js": true,
+}
+
+type Credentials struct {
+	SimulatorUser     string
+	SimulatorPassword string
+	ServiceToken      string
+	Admins            map[string]string // username to password
+}
+
+// loadCredentials builds the API credentials from the auth configuration. The simulator
+// credential defaults to the one used by the course simulator.
+func loadCredentials(cfg AuthConfig) Credentials {
+	creds := Credentials{
+		SimulatorUser:     cfg.SimulatorUser,
+		SimulatorPassword: cfg.SimulatorPassword,
+		ServiceToken:      cfg.ServiceToken,
+		Admins:            parseAdmins(cfg.AdminUsers),
+	}
+	if creds.ServiceToken == "" {
+		logger.Warn("SERVICE_TOKEN is not set, frontend requests will be rejected")
+	}
+	return creds
+}
+
+// parseAdmins reads a comma separated list of username:password pairs.
+func parseAdmins(value string) map[string]string {
+	admins := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		name, password, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found || name == "" || password == "" {
+			continue
+		}
+		admins[name] = password
+	}
+	return admins
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func (c Credentials) isSimulator(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	return ok && secureCompare(user, c.SimulatorUser) && secureCompare(password, c.SimulatorPassword)
+}
+
+func (c Credentials) isService(r *http.Request) bool {
+	token := r.Header.Get(SERVICE_TOKEN_HEADER)
+	return c.ServiceToken != "" && secureCompare(token, c.ServiceToken)
+}
+
+// isAdmin checks the basic auth credential against ADMIN_USERS and returns the admin's name.
+func (c Credentials) isAdmin(r *http.Request) (string, bool) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	expected, found := c.Admins[user]
+	if !found || !secureCompare(password, expected) {
+		return "", false
+	}
+	return user, true
+}
+
+// AuthMiddleware checks the credential required by the matched route. Simulator routes
+// accept the simulator or the service credential, admin routes an admin credential, and
+// every other route except the public ones is reserved for the frontend.
+func (api *API) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		template := routeTemplate(r)
+
+		var authorized bool
+		switch {
+		case publicRoutes[template]:
+			authorized = true
+		case simulatorRoutes[template]:
+			authorized = api.credentials.isSimulator(r) || api.credentials.isService(r)
+		case adminRoutes[template]:
+			_, authorized = api.credentials.isAdmin(r)
+		default:
+			authorized = api.credentials.isService(r)
+		}
+
+		if !authorized {
+			requestLogger(r).WithField("path", r.URL.Path).Warn("Unauthorized request")
+			api.metrics.BadRequests.WithLabelValues("unauthorized").Inc()
+			writeError(w, r, newAPIError(http.StatusForbidden, ERR_FORBIDDEN, "You are not authorized to use this resource!"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UserTokenMiddleware checks that requests to user routes carry a bearer token issued
+// by PostLoginHandler for the user named in the request.
+func (api *API) UserTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		template := routeTemplate(r)
+		if !userRoutes[r.Method+" "+template] || api.credentials.isSimulator(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		claims, err := api.tokens.Verify(token)
+		if err != nil {
+			requestLogger(r).WithError(err).WithField("path", r.URL.Path).Warn("Rejected user token")
+			api.metrics.BadRequests.WithLabelValues("unauthorized").Inc()
+			writeError(w, r, newAPIError(http.StatusUnauthorized, ERR_UNAUTHORIZED, "Missing or invalid token"))
+			return
+		}
+
+		matches := true
+		if username, ok := mux.Vars(r)["username"]; ok {
+			matches = username == claims.Subject
+		}
+		if template == "/followingmsgs" {
+			matches = r.URL.Query().Get("userid") == strconv.FormatUint(uint64(claims.UserID), 10)
+		}
+		if !matches {
+			requestLogger(r).WithFields(logrus.Fields{"path": r.URL.Path, "token_user": claims.Subject}).Warn("Token does not match requested user")
+			api.metrics.BadRequests.WithLabelValues("unauthorized").Inc()
+			writeError(w, r, newAPIError(http.StatusForbidden, ERR_FORBIDDEN, "You are not allowed to act on behalf of this user"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	template, _ := route.GetPathTemplate()
+	return template
+}
+
+type requestIDKey struct{}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		logger.WithError(err).Error("Failed to generate request id")
+	}
+	return hex.EncodeToString(id)
+}
+
+// RequestIDMiddleware takes the request id from X-Request-ID or generates one, and echoes
+// it in the response.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !contract.ValidRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the id RequestIDMiddleware gave the request, empty outside of it.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns the logger with the request id attached, for logs about a request.
+func requestLogger(r *http.Request) *logrus.Entry {
+	return logger.WithField("request_id", requestID(r))
+}
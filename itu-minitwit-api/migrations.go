@@ -0,0 +1,484 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Migration is one versioned schema change. Up and Down run inside a transaction, and
+// Down may be nil for changes that cannot be undone.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration in the schema_migrations table.
+type SchemaMigration struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"not null"`
+	AppliedAt time.Time
+}
+
+// Snapshots of the tables as a migration left them. Migrations must use these instead
+// of the models in types.go, which keep changing.
+type userV1 struct {
+	UserID   uint   `gorm:"column:user_id;primaryKey"`
+	Username string `gorm:"unique;not null"`
+	Email    string `gorm:"not null"`
+	PWHash   string `gorm:"not null"`
+}
+
+func (userV1) TableName() string { return "users" }
+
+type followerV1 struct {
+	WhoID  uint `gorm:"not null"`
+	WhomID uint `gorm:"not null"`
+}
+
+func (followerV1) TableName() string { return "followers" }
+
+type followerV2 struct {
+	WhoID  uint `gorm:"primaryKey;autoIncrement:false;not null"`
+	WhomID uint `gorm:"primaryKey;autoIncrement:false;not null"`
+}
+
+func (followerV2) TableName() string { return "followers" }
+
+type messageV1 struct {
+	MessageID uint   `gorm:"primaryKey"`
+	AuthorID  uint   `gorm:"not null"`
+	Text      string `gorm:"not null"`
+	PubDate   string `gorm:"not null"`
+	Flagged   uint   `gorm:"default:0"`
+}
+
+func (messageV1) TableName() string { return "messages" }
+
+type messageV2 struct {
+	MessageID uint      `gorm:"primaryKey"`
+	AuthorID  uint      `gorm:"not null"`
+	Text      string    `gorm:"not null"`
+	PubDate   time.Time `gorm:"not null"`
+	Flagged   uint      `gorm:"default:0"`
+}
+
+func (messageV2) TableName() string { return "messages" }
+
+type messageFlagV1 struct {
+	FlagID    uint      `gorm:"primaryKey"`
+	MessageID uint      `gorm:"not null;index:idx_message_flags_message_id"`
+	Action    string    `gorm:"not null"`
+	Moderator string    `gorm:"not null"`
+	Reason    string    `gorm:"not null;default:''"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+func (messageFlagV1) TableName() string { return "message_flags" }
+
+type simulatorLatestV1 struct {
+	ID     uint `gorm:"primaryKey;autoIncrement:false"`
+	Latest int  `gorm:"not null"`
+}
+
+func (simulatorLatestV1) TableName() string { return "simulator_latest" }
+
+type processedCommandV1 struct {
+	Latest      int64     `gorm:"primaryKey;autoIncrement:false"`
+	Method      string    `gorm:"not null"`
+	Path        string    `gorm:"not null"`
+	Status      int       `gorm:"not null"`
+	ContentType string    `gorm:"not null;default:''"`
+	Body        string    `gorm:"not null;default:''"`
+	CreatedAt   time.Time `gorm:"index:idx_processed_commands_created_at"`
+}
+
+func (processedCommandV1) TableName() string { return "processed_commands" }
+
+type processedCommandV2 struct {
+	Latest      int64     `gorm:"primaryKey;autoIncrement:false"`
+	Method      string    `gorm:"not null"`
+	Path        string    `gorm:"not null"`
+	BodyHash    string    `gorm:"not null;default:''"`
+	Status      int       `gorm:"not null"`
+	ContentType string    `gorm:"not null;default:''"`
+	Body        string    `gorm:"not null;default:''"`
+	CreatedAt   time.Time `gorm:"index:idx_processed_commands_created_at"`
+}
+
+func (processedCommandV2) TableName() string { return "processed_commands" }
+
+// schemaMigrations is the ordered history of the schema. Never edit or reorder a migration
+// that has been deployed, add a new one instead.
+func schemaMigrations(cfg DatabaseConfig) []Migration {
+	return []Migration{
+		{
+			// Databases created by the old AutoMigrate already have these tables
+			Version: 1,
+			Name:    "create_tables",
+			Up: func(tx *gorm.DB) error {
+				for _, table := range []interface{}{&userV1{}, &followerV1{}, &messageV1{}} {
+					if tx.Migrator().HasTable(table) {
+						continue
+					}
+					if err := tx.Migrator().CreateTable(table); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&messageV1{}, &followerV1{}, &userV1{})
+			},
+		},
+		{
+			// schema.sql used singular table names and unix seconds in pub_date
+			Version: 2,
+			Name:    "import_legacy_tables",
+			Up: func(tx *gorm.DB) error {
+				if tx.Migrator().HasTable("user") {
+					if err := tx.Exec(`INSERT INTO users (user_id, username, email, pw_hash)
+					SELECT user_id, username, email, pw_hash FROM "user"
+					WHERE user_id NOT IN (SELECT user_id FROM users)`).Error; err != nil {
+						return err
+					}
+				}
+				if tx.Migrator().HasTable("message") {
+					if err := tx.Exec(`INSERT INTO messages (message_id, author_id, text, pub_date, flagged)
+					SELECT message_id, author_id, text, CAST(COALESCE(pub_date, 0) AS TEXT), COALESCE(flagged, 0) FROM message
+					WHERE message_id NOT IN (SELECT message_id FROM messages)`).Error; err != nil {
+						return err
+					}
+				}
+				if tx.Migrator().HasTable("follower") {
+					if err := tx.Exec(`INSERT INTO followers (who_id, whom_id)
+					SELECT who_id, whom_id FROM follower WHERE who_id IS NOT NULL AND whom_id IS NOT NULL`).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Version: 3,
+			Name:    "pub_date_timestamp",
+			Up: func(tx *gorm.DB) error {
+				// Databases migrated by the earlier AutoMigrate version are already converted
+				text, err := isTextColumn(tx, &messageV1{}, "pub_date")
+				if err != nil || !text {
+					return err
+				}
+				if err := pubDateToTimestamp(tx); err != nil {
+					return err
+				}
+				return tx.Migrator().AlterColumn(&messageV2{}, "PubDate")
+			},
+			Down: func(tx *gorm.DB) error {
+				if err := pubDateToText(tx); err != nil {
+					return err
+				}
+				return tx.Migrator().AlterColumn(&messageV1{}, "PubDate")
+			},
+		},
+		{
+			// Drops duplicate rows and self-follows so (who_id, whom_id) can become the key
+			Version: 4,
+			Name:    "followers_primary_key",
+			Up: func(tx *gorm.DB) error {
+				return rebuildTable(tx, "followers", &followerV2{},
+					"SELECT DISTINCT who_id, whom_id FROM followers WHERE who_id <> whom_id")
+			},
+			Down: func(tx *gorm.DB) error {
+				return rebuildTable(tx, "followers", &followerV1{}, "SELECT who_id, whom_id FROM followers")
+			},
+		},
+		{
+			Version: 5,
+			Name:    "message_search_index",
+			Up:      createSearchIndex,
+			Down:    dropSearchIndex,
+		},
+		{
+			Version: 6,
+			Name:    "message_flags",
+			Up: func(tx *gorm.DB) error {
+				return tx.Migrator().CreateTable(&messageFlagV1{})
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&messageFlagV1{})
+			},
+		},
+		{
+			// Imports latest_processed_sim_action_id.txt, or LATEST_FILE, if it exists
+			Version: 7,
+			Name:    "simulator_latest",
+			Up: func(tx *gorm.DB) error {
+				return createSimulatorLatest(tx, cfg.LegacyLatestFile)
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&simulatorLatestV1{})
+			},
+		},
+		{
+			Version: 8,
+			Name:    "processed_commands",
+			Up: func(tx *gorm.DB) error {
+				return tx.Migrator().CreateTable(&processedCommandV1{})
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&processedCommandV1{})
+			},
+		},
+		{
+			// The web frontend stored follows with who_id and whom_id swapped until it
+			// started posting from the follower's side
+			Version: 9,
+			Name:    "swap_web_followers",
+			Up:      swapFollowers,
+			Down:    swapFollowers,
+		},
+		{
+			Version: 10,
+			Name:    "processed_commands_body_hash",
+			Up: func(tx *gorm.DB) error {
+				return tx.Migrator().AddColumn(&processedCommandV2{}, "BodyHash")
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropColumn(&processedCommandV2{}, "BodyHash")
+			},
+		},
+	}
+}
+
+// swapFollowers exchanges who_id and whom_id of every follow. An UPDATE could hit the
+// primary key while both directions of a pair exist, so the table is rebuilt instead.
+func swapFollowers(tx *gorm.DB) error {
+	return rebuildTable(tx, "followers", &followerV2{}, "SELECT whom_id, who_id FROM followers")
+}
+
+// rebuildTable recreates a table from a snapshot struct and fills it with the rows of the
+// query. SQLite cannot add constraints to an existing table, so this works on both backends.
+func rebuildTable(tx *gorm.DB, table string, model interface{}, query string) error {
+	rebuilt := table + "_rebuild"
+	if err := tx.Table(rebuilt).Migrator().CreateTable(model); err != nil {
+		return err
+	}
+	if err := tx.Exec("INSERT INTO " + rebuilt + " " + query).Error; err != nil {
+		return err
+	}
+	if err := tx.Migrator().DropTable(table); err != nil {
+		return err
+	}
+	return tx.Migrator().RenameTable(rebuilt, table)
+}
+
+func isTextColumn(tx *gorm.DB, table interface{}, column string) (bool, error) {
+	columns, err := tx.Migrator().ColumnTypes(table)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range columns {
+		if c.Name() == column {
+			kind := strings.ToLower(c.DatabaseTypeName())
+			return strings.Contains(kind, "text") || strings.Contains(kind, "char"), nil
+		}
+	}
+	return false, fmt.Errorf("column %s not found", column)
+}
+
+// Migrator applies and reverts migrations, recording them in schema_migrations.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// NewMigrator checks that the migration versions are unique and creates the
+// schema_migrations table if needed.
+func NewMigrator(db *gorm.DB, migrations []Migration) (*Migrator, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", sorted[i].Version)
+		}
+	}
+
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: sorted}, nil
+}
+
+// Applied returns the versions recorded in schema_migrations.
+func (m *Migrator) Applied() (map[int]SchemaMigration, error) {
+	var rows []SchemaMigration
+	if err := m.db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// lock serialises migrations between replicas starting at the same time. SQLite already
+// allows only one writer.
+func lock(tx *gorm.DB) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", 7070).Error
+}
+
+func isApplied(tx *gorm.DB, version int) (bool, error) {
+	var count int64
+	err := tx.Model(&SchemaMigration{}).Where("version = ?", version).Count(&count).Error
+	return count > 0, err
+}
+
+// Up applies every pending migration in order and returns how many ran.
+func (m *Migrator) Up() (int, error) {
+	count := 0
+	for _, migration := range m.migrations {
+		ran := false
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := lock(tx); err != nil {
+				return err
+			}
+			if applied, err := isApplied(tx, migration.Version); err != nil || applied {
+				return err
+			}
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			ran = true
+			return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&SchemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now().UTC(),
+			}).Error
+		})
+		if err != nil {
+			return count, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		if ran {
+			logger.WithField("version", migration.Version).Info("Applied migration " + migration.Name)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Down reverts the latest applied migrations, at most steps of them.
+func (m *Migrator) Down(steps int) (int, error) {
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+		migration := m.migrations[i]
+		ran := false
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := lock(tx); err != nil {
+				return err
+			}
+			if applied, err := isApplied(tx, migration.Version); err != nil || !applied {
+				return err
+			}
+			if migration.Down == nil {
+				return errors.New("migration cannot be reverted")
+			}
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			ran = true
+			return tx.Delete(&SchemaMigration{}, "version = ?", migration.Version).Error
+		})
+		if err != nil {
+			return count, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		if ran {
+			logger.WithField("version", migration.Version).Info("Reverted migration " + migration.Name)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Pending returns the migrations that have not been applied yet.
+func (m *Migrator) Pending() ([]Migration, error) {
+	applied, err := m.Applied()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+const MIGRATE_USAGE = `usage: minitwit-api migrate [command]
+
+commands:
+  up         apply all pending migrations (default)
+  down [n]   revert the last n migrations (default 1)
+  status     list migrations and whether they are applied`
+
+// runMigrateCommand implements the migrate subcommand.
+func runMigrateCommand(cfg DatabaseConfig, args []string) error {
+	db, err := connectDB(cfg)
+	if err != nil {
+		return err
+	}
+	migrator, err := NewMigrator(db, schemaMigrations(cfg))
+	if err != nil {
+		return err
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		count, err := migrator.Up()
+		fmt.Printf("Applied %d migration(s)\n", count)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		count, err := migrator.Down(steps)
+		fmt.Printf("Reverted %d migration(s)\n", count)
+		return err
+	case "status":
+		applied, err := migrator.Applied()
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrator.migrations {
+			status := "pending"
+			if row, ok := applied[migration.Version]; ok {
+				status = "applied " + row.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-30s %s\n", migration.Version, migration.Name, status)
+		}
+		return nil
+	default:
+		return errors.New(MIGRATE_USAGE)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SCHEMA_SQL is the schema.sql the project started from.
+const SCHEMA_SQL = `
+create table user (
+  user_id integer primary key autoincrement,
+  username string not null,
+  email string not null,
+  pw_hash string not null
+);
+create table follower (
+  who_id integer,
+  whom_id integer
+);
+create table message (
+  message_id integer primary key autoincrement,
+  author_id integer not null,
+  text string not null,
+  pub_date integer,
+  flagged integer
+)`
+
+// newBaselineDB creates the tables the way the AutoMigrate of the baseline left them,
+// before any versioned migration ran.
+func newBaselineDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := openTestDB(t)
+	if err := db.Migrator().CreateTable(&userV1{}, &followerV1{}, &messageV1{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSwapWebFollowers(t *testing.T) {
+	db := newBaselineDB(t)
+	for _, username := range []string{"alice", "bob", "carol"} {
+		if err := db.Create(&userV1{Username: username, Email: username + "@example.com", PWHash: "x"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The web stored "alice follows bob" as who=bob, whom=alice. Both directions of a
+	// pair must survive the swap.
+	for _, f := range []followerV1{{WhoID: 2, WhomID: 1}, {WhoID: 3, WhomID: 1}, {WhoID: 1, WhomID: 3}} {
+		if err := db.Create(&f).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := newTestMigrator(t, db).Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newGormStore(db)
+	following, err := store.ListFollowing(1, Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(following) != 2 {
+		t.Fatalf("alice follows %+v, want bob and carol", following)
+	}
+	if ok, err := store.IsFollowing(3, 1); err != nil || !ok {
+		t.Fatalf("carol should still follow alice: %v, %v", ok, err)
+	}
+}
+
+func countRows(t *testing.T, db *gorm.DB, table string) int64 {
+	t.Helper()
+	var count int64
+	if err := db.Table(table).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestMigrateBaselineDatabase(t *testing.T) {
+	db := newBaselineDB(t)
+	for _, username := range []string{"alice", "bob"} {
+		if err := db.Create(&userV1{Username: username, Email: username + "@example.com", PWHash: "x"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A duplicate and a self-follow, which the primary key of migration 4 drops
+	for _, f := range []followerV1{{WhoID: 2, WhomID: 1}, {WhoID: 2, WhomID: 1}, {WhoID: 1, WhomID: 1}} {
+		if err := db.Create(&f).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	message := messageV1{AuthorID: 1, Text: "hi", PubDate: time.Now().Format(LEGACY_PUB_DATE_LAYOUT)}
+	if err := db.Create(&message).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	migrator := newTestMigrator(t, db)
+	count, err := migrator.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := len(schemaMigrations(defaultConfig().Database)); count != total {
+		t.Fatalf("applied %d migrations, want %d", count, total)
+	}
+	if pending, err := migrator.Pending(); err != nil || len(pending) != 0 {
+		t.Fatalf("pending after Up: %v, %v", pending, err)
+	}
+
+	store := newGormStore(db)
+	if ok, err := store.IsFollowing(1, 2); err != nil || !ok {
+		t.Fatalf("alice should follow bob: %v, %v", ok, err)
+	}
+	if n := countRows(t, db, "followers"); n != 1 {
+		t.Fatalf("%d follows, want 1", n)
+	}
+	if _, err := store.GetMessage(message.MessageID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Running again, also from a new process, changes nothing
+	for _, m := range []*Migrator{migrator, newTestMigrator(t, db)} {
+		if count, err := m.Up(); err != nil || count != 0 {
+			t.Fatalf("second Up applied %d: %v", count, err)
+		}
+	}
+	if n := countRows(t, db, "followers"); n != 1 {
+		t.Fatalf("%d follows after running again, want 1", n)
+	}
+}
+
+func TestMigrateSchemaSQLDatabase(t *testing.T) {
+	db := openTestDB(t)
+	for _, statement := range strings.Split(SCHEMA_SQL, ";") {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	statements := []string{
+		`INSERT INTO user (username, email, pw_hash) VALUES ('alice', 'alice@example.com', 'x'), ('bob', 'bob@example.com', 'x')`,
+		`INSERT INTO follower (who_id, whom_id) VALUES (2, 1), (1, NULL)`,
+		`INSERT INTO message (author_id, text, pub_date, flagged) VALUES (1, 'hi', 1709647620, NULL)`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := newTestMigrator(t, db).Up(); err != nil {
+		t.Fatal(err)
+	}
+	store := newGormStore(db)
+	if _, err := store.GetUserByUsername("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, "followers"); n != 1 {
+		t.Fatalf("%d follows, want 1", n)
+	}
+	var imported messageV2
+	if err := db.First(&imported).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !imported.PubDate.Equal(time.Unix(1709647620, 0)) {
+		t.Fatalf("pub_date %s, want the unix timestamp", imported.PubDate)
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	db := openTestDB(t)
+	migrator := newTestMigrator(t, db)
+	total := len(schemaMigrations(defaultConfig().Database))
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := migrator.Down(1); err != nil || count != 1 {
+		t.Fatalf("Down(1) reverted %d: %v", count, err)
+	}
+	if pending, err := migrator.Pending(); err != nil || len(pending) != 1 || pending[0].Version != total {
+		t.Fatalf("pending after Down(1): %+v, %v", pending, err)
+	}
+
+	// Migration 2 cannot be reverted, everything after it is
+	count, err := migrator.Down(total)
+	if err == nil || !strings.Contains(err.Error(), "migration 2") {
+		t.Fatalf("expected migration 2 to refuse, got %v", err)
+	}
+	if count != total-3 {
+		t.Fatalf("reverted %d migrations, want %d", count, total-3)
+	}
+	if db.Migrator().HasTable(&processedCommandV1{}) || db.Migrator().HasTable(&messageFlagV1{}) {
+		t.Fatal("tables of reverted migrations still exist")
+	}
+
+	if count, err := migrator.Up(); err != nil || count != total-2 {
+		t.Fatalf("Up applied %d: %v", count, err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewMigratorRejectsDuplicateVersions(t *testing.T) {
+	migrations := []Migration{{Version: 1, Name: "a"}, {Version: 2, Name: "b"}, {Version: 1, Name: "c"}}
+	if _, err := NewMigrator(openTestDB(t), migrations); err == nil {
+		t.Fatal("expected an error for duplicate versions")
+	}
+}
@@ -1,18 +1,24 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
-	"strings"
+	"syscall"
 	"time"
 
+	"devoops/config"
+	"devoops/httpserver"
 	"github.com/gorilla/mux"
-	"github.com/gorilla/sessions"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
@@ -21,68 +27,47 @@ import (
 )
 
 // const DATABASE = "../minitwit.db"
-const PER_PAGE = 30
+const PER_PAGE = 30    // default of the page_size setting
+const DEFAULT_NO = 100 // page size when the simulator does not send ?no=
 const USER_NOT_FOUND = "User not found"
 
-var db *gorm.DB
-var err error
-var port = ":9090"
-var store = sessions.NewCookieStore([]byte("SESSION_KEY"))
-
 var logger = logrus.New()
 
+// initLogger logs JSON to stdout at info level, so access logs are kept. main lowers or
+// raises the level to the configured log_level.
 func initLogger() {
 	logger.SetFormatter(&logrus.JSONFormatter{})
 	logger.SetOutput(os.Stdout)
-	logger.SetLevel(logrus.WarnLevel)
+	logger.SetLevel(logrus.InfoLevel)
 }
 
 type API struct {
-	metrics *Metrics
-}
-
-func afterRequestLogging(start time.Time, r *http.Request) {
-	// Check if a request takes longer than 2 seconds
-
-	duration := time.Since(start)
-
-	if duration > 2*time.Second {
-		logger.WithFields(logrus.Fields{
-			"method":    r.Method,
-			"path":      r.URL.Path,
-			"duration":  duration,
-			"remote_ip": r.RemoteAddr,
-		}).Warn("Slow request detected")
-	} else {
-		logger.WithFields(logrus.Fields{
-			"method":    r.Method,
-			"path":      r.URL.Path,
-			"duration":  duration,
-			"remote_ip": r.RemoteAddr,
-		}).Info("Request completed quickly")
-	}
+	metrics     *Metrics
+	hasher      PasswordHasher
+	credentials Credentials
+	tokens      *TokenIssuer
+	commands    *CommandLog
+	store       Store
+	pageSize    int                // timeline and search page size
+	server      *httpserver.Server // readiness reports down once it starts draining
 }
 
-func connectDB() (*gorm.DB, error) {
+func connectDB(cfg DatabaseConfig) (*gorm.DB, error) {
+	var db *gorm.DB
+	var err error
 
-	host := os.Getenv("DB_HOST")
-
-	if host == "" {
-		dbPath := os.Getenv("DATABASE")
-		if dbPath == "" {
-			dbPath = "../minitwit.db"
-		}
-		logger.Info("Connecting to SQLite database", logrus.Fields{"path": dbPath})
-		db, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	if cfg.Host == "" {
+		logger.Info("Connecting to SQLite database", logrus.Fields{"path": cfg.Path})
+		db, err = gorm.Open(sqlite.Open(cfg.Path), &gorm.Config{})
 	} else { // postgresql remote
 		dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=require",
-			os.Getenv("DB_HOST"),
-			os.Getenv("DB_PORT"),
-			os.Getenv("DB_USER"),
-			os.Getenv("DB_PASSWORD"),
-			os.Getenv("DB_NAME"),
+			cfg.Host,
+			cfg.Port,
+			cfg.User,
+			cfg.Password,
+			cfg.Name,
 		)
-		logger.Info("Connecting to PostgreSQL database", logrus.Fields{"host": os.Getenv("DB_HOST")})
+		logger.Info("Connecting to PostgreSQL database", logrus.Fields{"host": cfg.Host})
 		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	}
 
@@ -95,457 +80,334 @@ func connectDB() (*gorm.DB, error) {
 	return db, nil
 }
 
-func FormatDateTime(timestamp int64) string {
-	t := time.Unix(timestamp, 0)
-	return t.Format("Jan 2, 2006 at 3:04PM")
-}
-
-func createDummyUser(api API, username string) (uint, error) {
+func createDummyUser(api *API, username string) (uint, error) {
 	// dummy workaround to get rid of errors caused by old api downtime
 
 	// Insert new user into the database
-	newUser := User{Username: username, Email: username + "@gmail.com", PWHash: "dummy"}
-	err := db.Create(&newUser).Error
+	newUser := User{Username: username, Email: username + "@gmail.com", PWHash: DUMMY_PASSWORD}
+	err := api.store.CreateUser(&newUser)
 	if err != nil {
 		log.Println("Error inserting user:", err)
 		return 0, err
 	}
 	log.Println("Added dummy user: ", newUser)
-	return api.getUserID(db, username)
+	return newUser.UserID, nil
 }
 
-func UpdateLatest(r *http.Request) {
-	parsedCommandID := -1
-	if latestParam := r.URL.Query().Get("latest"); latestParam != "" {
-		if id, err := strconv.Atoi(latestParam); err == nil {
-			parsedCommandID = id
-		}
+// updateLatest stores the ?latest= action id sent by the simulator.
+func (api *API) updateLatest(r *http.Request) {
+	latestParam := r.URL.Query().Get("latest")
+	if latestParam == "" {
+		return
 	}
-
-	if parsedCommandID != -1 {
-		file, err := os.Create("./latest_processed_sim_action_id.txt")
-		if err == nil {
-			defer func() {
-				err = file.Close()
-				if err != nil {
-					fmt.Print(err.Error())
-					return
-				}
-			}()
-			_, err = file.WriteString(strconv.Itoa(parsedCommandID))
-			if err != nil {
-				fmt.Print(err.Error())
-				return
-			}
-		}
+	id, err := strconv.Atoi(latestParam)
+	if err != nil {
+		return
+	}
+	err = api.store.StoreLatest(id)
+	if err != nil {
+		logger.WithError(err).WithField("latest", id).Error("Failed to store latest action ID")
 	}
 }
 
 func (api *API) GETLatestHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer afterRequestLogging(start, r)
-
-	logger.WithFields(logrus.Fields{
-		"method": r.Method,
-		"path":   r.URL.Path,
-		"ip":     r.RemoteAddr,
-	}).Info("Get latest request")
-	// Read the latest processed action ID from a file
-	UpdateLatest(r)
-	content, err := os.ReadFile("latest_processed_sim_action_id.txt")
+	// Read the latest processed action ID from the database
+	api.updateLatest(r)
+	latestID, err := api.store.LoadLatest()
 	if err != nil {
 		logger.WithError(err).Error("Failed to read latest action ID")
 		api.metrics.BadRequests.WithLabelValues("latest").Inc()
-		http.Error(w, "Failed to read latest action ID", http.StatusInternalServerError)
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Failed to read latest action ID"))
 		return
 	}
 
-	latestID := strings.TrimSpace(string(content))
-	if latestID == "" {
-		latestID = "-1"
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	latestID_int, _ := strconv.Atoi(latestID)
 	logger.WithFields(logrus.Fields{
-		"latest_id": latestID_int,
-	}).Info("Successfully retrieved latest action ID")
-
-	CheckEncodeResponse(w, map[string]int{"latest": latestID_int}, http.StatusCreated) //should it be StatusOK? the test passes, so i am leaving it like this for now.
-}
+		"latest_id": latestID,
+	}).Debug("Successfully retrieved latest action ID")
 
-func GetNumberHandler(r *http.Request) int {
-	parsedCommandID := 100
-	if number := r.URL.Query().Get("no"); number != "" {
-		if id, err := strconv.Atoi(number); err == nil {
-			parsedCommandID = id
-		}
-	}
-	return parsedCommandID
+	CheckEncodeResponse(w, LatestResponse{Latest: latestID}, http.StatusCreated) //should it be StatusOK? the test passes, so i am leaving it like this for now.
 }
 
 func (api *API) GETFollowerHandler(w http.ResponseWriter, r *http.Request) {
 
-	//number of requested followers
-	rowNums := GetNumberHandler(r)
-
-	start := time.Now()
-	defer afterRequestLogging(start, r)
-
-	logger.WithFields(logrus.Fields{
-		"method":    r.Method,
-		"path":      r.URL.Path,
-		"query":     r.URL.RawQuery,
-		"remote_ip": r.RemoteAddr,
-	}).Info("GETFollowerHandler called")
-
-	UpdateLatest(r)
+	api.updateLatest(r)
 	vars := mux.Vars(r)
 
-	userID, _ := api.getUserID(db, vars["username"])
+	userID, _ := api.getUserID(vars["username"])
 
 	if userID == 0 {
 		logger.WithField("username", vars["username"]).Warn(USER_NOT_FOUND)
 		api.metrics.BadRequests.WithLabelValues("get_follower").Inc()
-		http.Error(w, "Cannot find user", http.StatusNotFound)
+		writeError(w, r, newAPIError(http.StatusNotFound, ERR_USER_NOT_FOUND, "Cannot find user"))
 		return
 	}
 
-	// Query all followers
-
-	var followers []string
-
-	err = db.
-		Table("users").
-		Select("users.username").
-		Joins("INNER JOIN followers ON followers.whom_id = users.user_id").
-		Where("followers.who_id = ?", userID).
-		Limit(rowNums).
-		Pluck("username", &followers).
-		Error
+	page, err := parsePage(r, CURSOR_USER, DEFAULT_NO)
+	if err != nil {
+		api.metrics.BadRequests.WithLabelValues("get_follower").Inc()
+		writeError(w, r, newAPIError(http.StatusBadRequest, ERR_BAD_REQUEST, err.Error()))
+		return
+	}
 
+	// Query all followers
+	rows, err := api.store.ListFollowing(userID, page)
 	if err != nil {
 		logger.WithFields(logrus.Fields{"error": err.Error(), "userID": userID}).Error("Failed to fetch followers")
-		http.Error(w, "Query execution failed", http.StatusInternalServerError)
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Query execution failed"))
 		return
 	}
 
-	logger.WithField("follower_count", len(followers)).Info("Followers retrieved successfully")
-	response := map[string][]string{"follows": followers}
+	rows, cursors := paginate(page, CURSOR_USER, rows, func(u UserDetails) uint { return u.UserID })
+	followers := make([]string, 0, len(rows))
+	for _, row := range rows {
+		followers = append(followers, row.Username)
+	}
+
+	logger.WithField("follower_count", len(followers)).Debug("Followers retrieved successfully")
+	setPageHeaders(w, r, cursors)
+	response := FollowsResponse{Follows: followers, NextCursor: cursors.Next, PrevCursor: cursors.Prev}
 	CheckEncodeResponse(w, response, http.StatusOK)
 }
 
 func (api *API) POSTFollowerHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer afterRequestLogging(start, r)
-
-	UpdateLatest(r)
+	api.updateLatest(r)
 
 	vars := mux.Vars(r)
 
-	userID, _ := api.getUserID(db, vars["username"])
+	userID, _ := api.getUserID(vars["username"])
 
 	if userID == 0 {
 		logger.Warn(USER_NOT_FOUND, logrus.Fields{"username": vars["username"]})
 		api.metrics.BadRequests.WithLabelValues("post_follower").Inc()
-		http.Error(w, "Cannot find user", http.StatusNotFound)
+		writeError(w, r, newAPIError(http.StatusNotFound, ERR_USER_NOT_FOUND, "Cannot find user"))
 		return
 	}
 
-	var data map[string]interface{}
-
-	//TODO: CheckDecodeResponse
-	err = json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		fmt.Print(err.Error())
+	var req FollowRequest
+	if !decodeRequest(w, r, &req) {
+		api.metrics.BadRequests.WithLabelValues("post_follower").Inc()
 		return
 	}
 
-	if followsUsername, exists := data["follow"]; exists {
-		followsUserID, _ := api.getUserID(db, followsUsername.(string))
+	if followsUsername := req.Follow; followsUsername != "" {
+		followsUserID, _ := api.getUserID(followsUsername)
 		if followsUserID == 0 {
 			logger.Warn("Follow target user not found", logrus.Fields{"target_user": followsUsername})
 			api.metrics.BadRequests.WithLabelValues("post_follower").Inc()
-			http.Error(w, "The user you are trying to follow cannot be found", http.StatusNotFound)
+			writeError(w, r, newAPIError(http.StatusNotFound, ERR_USER_NOT_FOUND, "The user you are trying to follow cannot be found"))
 			return
 		}
 
-		// Insert follow relationship
-		follower := Follower{WhoID: userID, WhomID: followsUserID}
-
-		err := db.Create(&follower).Error
+		if followsUserID == userID {
+			api.metrics.BadRequests.WithLabelValues("post_follower").Inc()
+			writeError(w, r, newAPIError(http.StatusUnprocessableEntity, ERR_CANNOT_FOLLOW_SELF, "You cannot follow yourself"))
+			return
+		}
 
+		// Insert follow relationship, following twice is not an error
+		followed, err := api.store.Follow(userID, followsUserID)
 		if err != nil {
 			logger.WithError(err).Error("Failed to insert follow relationship")
 			api.metrics.BadRequests.WithLabelValues("post_follower").Inc()
-			http.Error(w, "Failed to follow user", http.StatusBadRequest)
+			writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Failed to follow user"))
 			return
 		}
+
+		response := FollowResponse{Follow: followsUsername, Status: FOLLOW_STATUS_FOLLOWED}
+		if !followed {
+			response.Status = FOLLOW_STATUS_ALREADY_FOLLOWING
+		}
 		api.metrics.FollowRequests.WithLabelValues("follow").Inc()
-		CheckEncodeResponse(w, data, http.StatusOK)
+		CheckEncodeResponse(w, response, http.StatusOK)
 		return
 
-	} else if unfollowsUsername, exists := data["unfollow"]; exists {
-		unfollowsUserID, _ := api.getUserID(db, unfollowsUsername.(string))
+	} else if unfollowsUsername := req.Unfollow; unfollowsUsername != "" {
+		unfollowsUserID, _ := api.getUserID(unfollowsUsername)
 		if unfollowsUserID == 0 {
 			api.metrics.BadRequests.WithLabelValues("post_follower").Inc()
-			http.Error(w, "The user you are trying to unfollow cannot be found", http.StatusNotFound)
+			writeError(w, r, newAPIError(http.StatusNotFound, ERR_USER_NOT_FOUND, "The user you are trying to unfollow cannot be found"))
 			return
 		}
 		// Delete follow relationship
-		err := db.Where("who_id = ? AND whom_id = ?", userID, unfollowsUserID).Delete(&Follower{}).Error
-
+		unfollowed, err := api.store.Unfollow(userID, unfollowsUserID)
 		if err != nil {
 			api.metrics.BadRequests.WithLabelValues("post_follower").Inc()
-			http.Error(w, "Failed to unfollow user", http.StatusBadRequest)
+			writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Failed to unfollow user"))
 			return
 		}
 
 		logger.WithFields(logrus.Fields{
 			"user":   vars["username"],
-			"target": followsUsername,
-		}).Info("User followed successfully")
+			"target": unfollowsUsername,
+		}).Debug("User unfollowed successfully")
 
+		response := FollowResponse{Unfollow: unfollowsUsername, Status: FOLLOW_STATUS_UNFOLLOWED}
+		if !unfollowed {
+			response.Status = FOLLOW_STATUS_NOT_FOLLOWING
+		}
 		api.metrics.UnfollowRequests.WithLabelValues("unfollow").Inc()
-		CheckEncodeResponse(w, data, http.StatusOK)
+		CheckEncodeResponse(w, response, http.StatusOK)
 		return
 	}
-
 }
 
 func (api *API) RegisterHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer afterRequestLogging(start, r)
-
-	UpdateLatest(r) // Updater the latest parameter
-
-	logger.WithFields(logrus.Fields{
-		"method":    r.Method,
-		"path":      r.URL.Path,
-		"remote_ip": r.RemoteAddr,
-	}).Info("RegisterHandler called")
+	api.updateLatest(r) // Updater the latest parameter
 
-	var error = ""
+	var req RegisterRequest
+	if !decodeRequest(w, r, &req) {
+		api.metrics.BadRequests.WithLabelValues("register").Inc()
+		return
+	}
 
-	var data map[string]interface{}
-	//TODO: CheckDecodeResponse
-	err = json.NewDecoder(r.Body).Decode(&data)
+	// Check if the username is already taken
+	userId, err := api.getUserID(req.Username)
 	if err != nil {
-		fmt.Print(err.Error())
+		logger.WithError(err).Error("Error looking up user")
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Failed to register user"))
 		return
 	}
-
-	username, email, password := data["username"].(string), data["email"].(string), data["pwd"].(string)
-
-	logger.WithFields(logrus.Fields{"username": username, "email": email}).Debug("Validating registration input")
-
-	// Validate input fields
-	if username == "" {
-		error = "You have to enter a username"
-	} else if email == "" {
-		error = "You have to enter a valid email address"
-	} else if !strings.Contains(email, "@") {
-		error = "You have to enter a valid email address"
-	} else if password == "" {
-		error = "You have to enter a password"
-	} else {
-		// Check if the username is already taken
-		userId, _ := api.getUserID(db, username)
-
-		if userId != 0 {
-			error = "The username is already taken"
-		} else {
-			// Insert new user into the database
-			newUser := User{Username: username, Email: email, PWHash: password}
-			err := db.Create(&newUser).Error
-			if err != nil {
-				logger.WithError(err).Error("Error inserting user")
-				log.Println("Error inserting user:", err)
-				http.Error(w, "Failed to register user", http.StatusInternalServerError)
-				return
-			}
-		}
+	if userId != 0 {
+		logger.WithField("username", req.Username).Warn("The username is already taken")
+		api.metrics.BadRequests.WithLabelValues("register").Inc()
+		apiErr := newAPIError(http.StatusBadRequest, ERR_USERNAME_TAKEN, "The username is already taken")
+		apiErr.Fields = map[string]string{"username": apiErr.ErrorMsg}
+		writeError(w, r, apiErr)
+		return
 	}
-	var status int
 
-	if error == "" {
-		logger.WithField("username", username).Info("User registered successfully")
-		api.metrics.SuccessfulRequests.WithLabelValues("register").Inc()
-		w.WriteHeader(http.StatusNoContent)
-		status = http.StatusNoContent
-	} else {
-		logger.Warn(error)
-		api.metrics.BadRequests.WithLabelValues("register").Inc()
-		w.WriteHeader(http.StatusBadRequest)
-		status = http.StatusBadRequest
+	pwHash, err := api.hasher.Hash(req.Password)
+	if err != nil {
+		logger.WithError(err).Error("Error hashing password")
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Failed to register user"))
+		return
 	}
-	response := map[string]interface{}{
-		"status":    status,
-		"error_msg": error,
+
+	// Insert new user into the database
+	newUser := User{Username: req.Username, Email: req.Email, PWHash: pwHash}
+	err = api.store.CreateUser(&newUser)
+	if err != nil {
+		logger.WithError(err).Error("Error inserting user")
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Failed to register user"))
+		return
 	}
 
-	//this returns status ok only to show that the encoding worked, the status for registering user is stored in response map.
-	CheckEncodeResponse(w, response, status)
+	logger.WithField("username", req.Username).Debug("User registered successfully")
+	api.metrics.SuccessfulRequests.WithLabelValues("register").Inc()
+	CheckEncodeResponse(w, nil, http.StatusNoContent)
 }
 
 func (api *API) GETAllMessagesHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer afterRequestLogging(start, r)
-
-	UpdateLatest(r)
+	api.updateLatest(r)
 
-	logger.WithFields(logrus.Fields{
-		"method":    r.Method,
-		"path":      r.URL.Path,
-		"query":     r.URL.RawQuery,
-		"remote_ip": r.RemoteAddr,
-	}).Info("GETAllMessagesHandler called")
+	page, err := parsePage(r, CURSOR_MESSAGE, DEFAULT_NO)
+	if err != nil {
+		api.metrics.BadRequests.WithLabelValues("get_messages").Inc()
+		writeError(w, r, newAPIError(http.StatusBadRequest, ERR_BAD_REQUEST, err.Error()))
+		return
+	}
 
 	// Retrieve all non-flagged messages
-	var messages []APIMessage
-	err = db.Table("messages").
-		Select("messages.text AS content, messages.pub_date AS pub_date, users.username AS user").
-		Joins("JOIN users ON messages.author_id = users.user_id").
-		Where("messages.flagged = 0").
-		Order("messages.message_id DESC").
-		Limit(GetNumberHandler(r)).
-		Find(&messages).Error
-
+	messages, err := api.store.ListMessages(MessageFilter{}, page)
 	if err != nil {
 		logger.WithError(err).Error("Failed to fetch messages")
 		api.metrics.BadRequests.WithLabelValues("get_messages").Inc()
-		http.Error(w, "Query execution failed", http.StatusInternalServerError)
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Query execution failed"))
 		return
 	}
 
-	logger.WithField("message_count", len(messages)).Info("Messages retrieved successfully")
+	messages, cursors := paginate(page, CURSOR_MESSAGE, messages, func(m APIMessage) uint { return m.MessageID })
+	logger.WithField("message_count", len(messages)).Debug("Messages retrieved successfully")
 	api.metrics.SuccessfulRequests.WithLabelValues("msgs").Inc()
 
-	w.Header().Set("Content-Type", "application/json")
-	if len(messages) == 0 {
-		_, err = w.Write([]byte("[]"))
-		if err != nil {
-			fmt.Print(err.Error())
-		}
-		return
-	}
-
-	var filteredMsgs []map[string]string
-
-	for _, msg := range messages {
-		filteredMsg := map[string]string{
-			"content":  msg.Content,
-			"pub_date": msg.PubDate,
-			"user":     msg.User,
-		}
-		filteredMsgs = append(filteredMsgs, filteredMsg)
-	}
+	setPageHeaders(w, r, cursors)
+	filteredMsgs := toMessageResponses(messages)
 
 	CheckEncodeResponse(w, filteredMsgs, http.StatusOK)
 }
 
 func (api *API) GETUserMessagesHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer afterRequestLogging(start, r)
-
-	UpdateLatest(r)
+	api.updateLatest(r)
 
 	username := mux.Vars(r)["username"]
 
-	logger.WithFields(logrus.Fields{
-		"method":    r.Method,
-		"path":      r.URL.Path,
-		"username":  username,
-		"remote_ip": r.RemoteAddr,
-	}).Info("GETUserMessagesHandler called")
-
 	// Get user ID
-	userID, err := api.getUserID(db, username)
+	userID, err := api.getUserID(username)
 	if err != nil || userID == 0 {
-		logger.WithField("username", username).Warn(USER_NOT_FOUND)
-		fmt.Printf("Cannot find user: %s", username)
-		http.Error(w, "Cannot find user", http.StatusNotFound)
+		requestLogger(r).WithField("username", username).Warn(USER_NOT_FOUND)
+		writeError(w, r, newAPIError(http.StatusNotFound, ERR_USER_NOT_FOUND, "Cannot find user"))
 		api.metrics.BadRequests.WithLabelValues("get_user_messages").Inc()
 		return
 	}
 
-	// Retrieve messages
-	var messages []APIMessage
-	err = db.Table("messages").
-		Select("messages.text AS content, messages.pub_date AS pub_date, users.username AS user").
-		Joins("JOIN users ON messages.author_id = users.user_id").
-		Where("messages.flagged = 0 AND users.user_id = ?", userID).
-		Order("messages.message_id DESC").
-		Limit(GetNumberHandler(r)).
-		Find(&messages).Error
+	page, err := parsePage(r, CURSOR_MESSAGE, DEFAULT_NO)
+	if err != nil {
+		api.metrics.BadRequests.WithLabelValues("get_user_messages").Inc()
+		writeError(w, r, newAPIError(http.StatusBadRequest, ERR_BAD_REQUEST, err.Error()))
+		return
+	}
 
+	// Retrieve messages
+	messages, err := api.store.ListMessages(MessageFilter{AuthorID: userID}, page)
 	if err != nil {
 		logger.WithError(err).Error("Failed to fetch user messages")
-		http.Error(w, "Query execution failed", http.StatusInternalServerError)
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Query execution failed"))
 		return
 	}
 
+	messages, cursors := paginate(page, CURSOR_MESSAGE, messages, func(m APIMessage) uint { return m.MessageID })
+	setPageHeaders(w, r, cursors)
+
 	api.metrics.SuccessfulRequests.WithLabelValues("get_user_messages").Inc()
-	logger.WithField("message_count", len(messages)).Info("User messages retrieved successfully")
+	logger.WithField("message_count", len(messages)).Debug("User messages retrieved successfully")
 
-	// Ensure empty response is always a valid JSON array
-	w.Header().Set("Content-Type", "application/json")
-	if len(messages) == 0 {
-		_, err = w.Write([]byte("[]"))
-		if err != nil {
-			fmt.Print(err.Error())
-		}
+	// An empty page is still a JSON array
+	filteredMsgs := toMessageResponses(messages)
+	CheckEncodeResponse(w, filteredMsgs, http.StatusOK)
+}
+
+// GETMessageHandler returns a single message by id. Flagged messages are hidden here too.
+func (api *API) GETMessageHandler(w http.ResponseWriter, r *http.Request) {
+	messageID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		api.metrics.BadRequests.WithLabelValues("get_message").Inc()
+		writeError(w, r, newAPIError(http.StatusBadRequest, ERR_BAD_REQUEST, "Invalid message id"))
 		return
 	}
 
-	var filteredMsgs []map[string]string
-
-	for _, msg := range messages {
-		filteredMsg := map[string]string{
-			"content":  msg.Content,
-			"pub_date": msg.PubDate,
-			"user":     msg.User,
-		}
-		filteredMsgs = append(filteredMsgs, filteredMsg)
+	message, err := api.store.GetMessage(uint(messageID))
+	if errors.Is(err, ErrNotFound) {
+		api.metrics.BadRequests.WithLabelValues("get_message").Inc()
+		writeError(w, r, newAPIError(http.StatusNotFound, ERR_MESSAGE_NOT_FOUND, "Cannot find message"))
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to fetch message")
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Query execution failed"))
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-
-	CheckEncodeResponse(w, filteredMsgs, http.StatusOK)
+	api.metrics.SuccessfulRequests.WithLabelValues("get_message").Inc()
+	CheckEncodeResponse(w, toMessageResponse(message), http.StatusOK)
 }
 
 func (api *API) POSTMessagesHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer afterRequestLogging(start, r)
-
-	UpdateLatest(r)
+	api.updateLatest(r)
 
 	username := mux.Vars(r)["username"]
 
-	logger.WithFields(logrus.Fields{
-		"method":    r.Method,
-		"path":      r.URL.Path,
-		"username":  username,
-		"remote_ip": r.RemoteAddr,
-	}).Info("POSTMessagesHandler called")
-
 	// Get user ID
-	userID, err := api.getUserID(db, username)
+	userID, err := api.getUserID(username)
 	if err != nil || userID == 0 {
-		logger.WithField("username", username).Warn(USER_NOT_FOUND)
-		fmt.Printf("Cannot find user: %s", username)
-		http.Error(w, "Cannot find user", http.StatusNotFound)
+		requestLogger(r).WithField("username", username).Warn(USER_NOT_FOUND)
+		writeError(w, r, newAPIError(http.StatusNotFound, ERR_USER_NOT_FOUND, "Cannot find user"))
 		return
 	}
 
 	// Read and decode request body
-	var data struct {
-		Content string `json:"content"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || strings.TrimSpace(data.Content) == "" {
-		w.Header().Set("Content-Type", "application/json")
-		logger.Warn("Invalid or missing content in request")
-		http.Error(w, `{"status":400, "error_msg":"Invalid or missing content"}`, http.StatusBadRequest)
+	var data MessageRequest
+	if !decodeRequest(w, r, &data) {
+		api.metrics.BadRequests.WithLabelValues("tweet").Inc()
 		return
 	}
 
@@ -553,74 +415,52 @@ func (api *API) POSTMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	message := Message{
 		AuthorID: userID,
 		Text:     data.Content,
-		PubDate:  FormatDateTime(time.Now().Unix()),
+		PubDate:  time.Now().UTC(),
 		Flagged:  0,
 	}
 
 	// Insert into DB
-	if err := db.Create(&message).Error; err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if err := api.store.CreateMessage(&message); err != nil {
 		logger.WithError(err).Error("Failed to insert message into database")
-
-		CheckEncodeResponse(w, map[string]interface{}{
-			"status": http.StatusBadRequest, //not sure we need status in the map!
-			"res":    "",
-		}, http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Failed to post message"))
 		return
 	}
 
-	logger.WithField("username", username).Info("Message posted successfully")
+	logger.WithField("username", username).Debug("Message posted successfully")
 	api.metrics.MessagesSent.WithLabelValues("tweet").Inc()
 
 	// Successful response
 	api.metrics.SuccessfulRequests.WithLabelValues("tweet").Inc()
-	CheckEncodeResponse(w, map[string]interface{}{
-		"status": http.StatusNoContent, //not sure we need status in map!
-		"res":    "",
-	}, http.StatusNoContent)
+	CheckEncodeResponse(w, nil, http.StatusNoContent)
 }
 
 func (api *API) GETUserDetailsHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer afterRequestLogging(start, r)
-
 	userID := r.URL.Query().Get("user_id")
 	username := r.URL.Query().Get("username")
 
-	logger.WithFields(logrus.Fields{
-		"method":    r.Method,
-		"path":      r.URL.Path,
-		"userID":    userID,
-		"username":  username,
-		"remote_ip": r.RemoteAddr,
-	}).Info("GETUserDetailsHandler called")
-
 	var user User
+	var err error
 	if userID != "" {
-		result := db.Where("user_id = ?", userID).First(&user)
-		if result.Error != nil {
-			if result.Error == gorm.ErrRecordNotFound {
-				http.Error(w, USER_NOT_FOUND, http.StatusNotFound)
-			} else {
-				http.Error(w, "Database error: "+result.Error.Error(), http.StatusInternalServerError)
-			}
+		id, parseErr := strconv.ParseUint(userID, 10, 64)
+		if parseErr != nil {
+			writeError(w, r, newAPIError(http.StatusNotFound, ERR_USER_NOT_FOUND, USER_NOT_FOUND))
 			return
 		}
+		user, err = api.store.GetUserByID(uint(id))
 	} else if username != "" {
-		result := db.Where("username = ?", username).First(&user)
-		if result.Error != nil {
-			if result.Error == gorm.ErrRecordNotFound {
-				http.Error(w, USER_NOT_FOUND, http.StatusNotFound)
-			} else {
-				http.Error(w, "Database error: "+result.Error.Error(), http.StatusInternalServerError)
-			}
-			return
-		}
+		user, err = api.store.GetUserByUsername(username)
 	} else {
 		// If neither user_id nor username is provided, return an error
 		logger.Warn("Missing user_id or username query parameter")
 		api.metrics.BadRequests.WithLabelValues("get_user_details").Inc()
-		http.Error(w, "Missing user_id or username query parameter", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, ERR_BAD_REQUEST, "Missing user_id or username query parameter"))
+		return
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, newAPIError(http.StatusNotFound, ERR_USER_NOT_FOUND, USER_NOT_FOUND))
+		return
+	} else if err != nil {
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Database error"))
 		return
 	}
 
@@ -630,197 +470,152 @@ func (api *API) GETUserDetailsHandler(w http.ResponseWriter, r *http.Request) {
 		Email:    user.Email,
 	}
 
-	logger.WithFields(logrus.Fields{"userID": user.UserID, "username": user.Username}).Info("User details retrieved successfully")
+	logger.WithFields(logrus.Fields{"userID": user.UserID, "username": user.Username}).Debug("User details retrieved successfully")
 	api.metrics.SuccessfulRequests.WithLabelValues("get_user_details").Inc()
 	CheckEncodeResponse(w, userDetails, http.StatusOK)
 }
 
 func (api *API) GETFollowingHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer afterRequestLogging(start, r)
-
 	whoUsername := r.URL.Query().Get("whoUsername")
 	whomUsername := r.URL.Query().Get("whomUsername")
-	whoUsernameID, _ := api.getUserID(db, whoUsername)
-	whomUsernameID, _ := api.getUserID(db, whomUsername)
-
-	logger.WithFields(logrus.Fields{
-		"method":       r.Method,
-		"path":         r.URL.Path,
-		"whoUsername":  whoUsername,
-		"whomUsername": whomUsername,
-		"remote_ip":    r.RemoteAddr,
-	}).Info("GETFollowingHandler called")
-
-	var isFollowing bool = true
-	var follower Follower
-	err = db.Model(&Follower{}).
-		Where("who_id = ? AND whom_id = ?", whoUsernameID, whomUsernameID).
-		First(&follower).Error
+	whoUsernameID, _ := api.getUserID(whoUsername)
+	whomUsernameID, _ := api.getUserID(whomUsername)
 
+	isFollowing, err := api.store.IsFollowing(whoUsernameID, whomUsernameID)
 	if err != nil {
-		isFollowing = false // Default to false if no rows found or any error occurs
+		isFollowing = false // Default to false if any error occurs
 	}
 
-	logger.WithField("is_following", isFollowing).Info("Following status retrieved successfully")
+	logger.WithField("is_following", isFollowing).Debug("Following status retrieved successfully")
 	api.metrics.SuccessfulRequests.WithLabelValues("get_following").Inc()
 	CheckEncodeResponse(w, isFollowing, http.StatusOK)
 }
 
 func (api *API) PostLoginHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer afterRequestLogging(start, r)
-
 	var req LoginRequest
 
-	logger.WithFields(logrus.Fields{
-		"method":    r.Method,
-		"path":      r.URL.Path,
-		"remote_ip": r.RemoteAddr,
-	}).Info("PostLoginHandler called")
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.WithError(err).Warn("Invalid request body received")
+	if !decodeRequest(w, r, &req) {
 		api.metrics.BadRequests.WithLabelValues("post_login").Inc()
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
 	// Check if user exists
-	var foundUser User
-	result := db.Debug().Where("username = ?", req.Username).First(&foundUser) //db.Where("username = ?", req.Username).First(&foundUser)
-	if result.Error == gorm.ErrRecordNotFound {
+	foundUser, err := api.store.GetUserByUsername(req.Username)
+	if errors.Is(err, ErrNotFound) {
 		logger.WithField("username", req.Username).Warn("Invalid login credentials")
 		api.metrics.BadRequests.WithLabelValues("post_login").Inc()
-		http.Error(w, "Invalid credentials", http.StatusNotFound)
+		writeError(w, r, newAPIError(http.StatusNotFound, ERR_USER_NOT_FOUND, "Invalid credentials"))
 		return
-	} else if result.Error != nil {
-		logger.WithError(result.Error).Error("Database error during login")
+	} else if err != nil {
+		logger.WithError(err).Error("Database error during login")
 		api.metrics.BadRequests.WithLabelValues("post_login").Inc()
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Database error"))
 		return
 	}
 
 	// At this point we know that a user exists
 	// Check the password hash against the one found in the db
-	if req.Password == foundUser.PWHash {
-		logger.WithField("username", req.Username).Info("User logged in successfully")
-		api.metrics.SuccessfulRequests.WithLabelValues("post_login").Inc()
-		w.WriteHeader(http.StatusOK)
-	} else {
+	ok, needsUpgrade, err := checkPassword(api.hasher, foundUser.PWHash, req.Password)
+	if errors.Is(err, ErrDummyPassword) {
+		logger.WithField("username", req.Username).Warn("Login attempt on dummy user")
+		api.metrics.BadRequests.WithLabelValues("post_login").Inc()
+		writeError(w, r, newAPIError(http.StatusUnauthorized, ERR_INVALID_CREDENTIALS, "Invalid credentials"))
+		return
+	} else if err != nil {
+		logger.WithError(err).WithField("username", req.Username).Error("Failed to verify password")
+		api.metrics.BadRequests.WithLabelValues("post_login").Inc()
+		writeError(w, r, newAPIError(http.StatusUnauthorized, ERR_INVALID_CREDENTIALS, "Invalid credentials"))
+		return
+	}
+
+	if !ok {
 		logger.WithField("username", req.Username).Warn("Invalid password attempt")
 		api.metrics.BadRequests.WithLabelValues("post_login").Inc()
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		writeError(w, r, newAPIError(http.StatusUnauthorized, ERR_INVALID_PASSWORD, "Invalid credentials"))
+		return
+	}
+
+	// Rows from before password hashing still hold the plaintext password, replace it now
+	// that we know the password is correct. A failure here should not block the login.
+	if needsUpgrade {
+		pwHash, err := api.hasher.Hash(req.Password)
+		if err == nil {
+			err = api.store.UpdatePasswordHash(foundUser.UserID, pwHash)
+		}
+		if err != nil {
+			logger.WithError(err).WithField("username", req.Username).Error("Failed to upgrade password hash")
+		} else {
+			logger.WithField("username", req.Username).Info("Upgraded password hash")
+		}
+	}
+
+	token, expiresAt, err := api.tokens.Issue(foundUser)
+	if err != nil {
+		logger.WithError(err).Error("Failed to issue token")
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Failed to issue token"))
 		return
 	}
 
+	logger.WithField("username", req.Username).Debug("User logged in successfully")
+	api.metrics.SuccessfulRequests.WithLabelValues("post_login").Inc()
+	CheckEncodeResponse(w, LoginResponse{Token: token, ExpiresAt: expiresAt.Unix()}, http.StatusOK)
 }
 
 func (api *API) GetFollowingMessages(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer afterRequestLogging(start, r)
-
 	var userID = r.URL.Query().Get("userid")
 
-	logger.WithFields(logrus.Fields{
-		"method":    r.Method,
-		"path":      r.URL.Path,
-		"userID":    userID,
-		"remote_ip": r.RemoteAddr,
-	}).Info("GetFollowingMessages called")
-
-	var messages []APIMessage
-
-	err = db.Table("messages").
-		Select("messages.text AS content, messages.pub_date AS pub_date, users.username AS user").
-		Joins("JOIN users ON users.user_id = messages.author_id").
-		Where("flagged = ? AND (author_id = ? OR author_id IN (SELECT who_id FROM followers WHERE whom_id = ?))", 0, userID, userID).
-		Order("messages.message_id DESC").
-		Limit(PER_PAGE).
-		Find(&messages).Error
-
+	page, err := parsePage(r, CURSOR_MESSAGE, api.pageSize)
 	if err != nil {
-		fmt.Println(err.Error())
-		logger.WithError(err).Error("Failed to fetch following messages")
 		api.metrics.BadRequests.WithLabelValues("get_following_messages").Inc()
-		http.Error(w, "Query execution failed", http.StatusInternalServerError)
+		writeError(w, r, newAPIError(http.StatusBadRequest, ERR_BAD_REQUEST, err.Error()))
 		return
 	}
 
-	// Convert to Json app Message format
-
-	var filteredMsgs []map[string]string
-
-	for _, msg := range messages {
-		filteredMsg := map[string]string{
-			"content":  msg.Content,
-			"pub_date": msg.PubDate,
-			"user":     msg.User,
-		}
-		filteredMsgs = append(filteredMsgs, filteredMsg)
-	}
-	api.metrics.SuccessfulRequests.WithLabelValues("get_following_messages").Inc()
-	logger.WithField("message_count", len(messages)).Info("Following messages retrieved successfully")
-
-	w.Header().Set("Content-Type", "application/json")
-	CheckEncodeResponse(w, filteredMsgs, http.StatusOK)
-}
-
-func getPort() {
-	port = os.Getenv("PORT")
-	if port == "" {
-		port = ":9090"
+	timelineOf, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		api.metrics.BadRequests.WithLabelValues("get_following_messages").Inc()
+		writeError(w, r, newAPIError(http.StatusBadRequest, ERR_BAD_REQUEST, "Invalid user id"))
+		return
 	}
-}
 
-// gemini suggested: takes any type of response, and also the status code we expect in case of successful request
-func CheckEncodeResponse(w http.ResponseWriter, response interface{}, statusCode int) {
-	w.WriteHeader(statusCode)
-	err := json.NewEncoder(w).Encode(response)
+	messages, err := api.store.ListMessages(MessageFilter{TimelineOf: uint(timelineOf)}, page)
 	if err != nil {
-		log.Printf("Error encoding response: %v", err)
-		// If encoding fails, override the status code to 500.
-		if statusCode != http.StatusInternalServerError {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+		requestLogger(r).WithError(err).Error("Failed to fetch following messages")
+		api.metrics.BadRequests.WithLabelValues("get_following_messages").Inc()
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Query execution failed"))
+		return
 	}
-}
 
-//Gemini suggestion for handling nocontent status as we currently get some warnings in the tests, even though they pass.
-// func CheckResponse(w http.ResponseWriter, response interface{}, statusCode int) {
-//     w.WriteHeader(statusCode)
-//     if statusCode != http.StatusNoContent && statusCode != http.StatusNotModified {
-//             err := json.NewEncoder(w).Encode(response)
-//             if err != nil {
-//                     log.Printf("Error encoding response: %v", err)
-//                     if statusCode != http.StatusInternalServerError {
-//                             w.WriteHeader(http.StatusInternalServerError)
-//                     }
-//             }
-//     }
-//}
-
-//TODO: CheckDecodeResponse()
+	messages, cursors := paginate(page, CURSOR_MESSAGE, messages, func(m APIMessage) uint { return m.MessageID })
 
-func main() {
-	initLogger()
-	initDB()
-	store.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   3600 * 16, // 16 hours
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-	}
+	// Convert to Json app Message format
 
-	getPort()
+	filteredMsgs := toMessageResponses(messages)
+	api.metrics.SuccessfulRequests.WithLabelValues("get_following_messages").Inc()
+	logger.WithField("message_count", len(messages)).Debug("Following messages retrieved successfully")
 
-	metrics := InitMetrics()      // Initialize metrics
-	api := &API{metrics: metrics} // Initialize API with metrics
+	setPageHeaders(w, r, cursors)
+	CheckEncodeResponse(w, filteredMsgs, http.StatusOK)
+}
 
-	// Create a new mux router
+// newRouter registers the API routes behind the auth and idempotency middleware.
+func newRouter(api *API) *mux.Router {
 	r := mux.NewRouter()
+	unmatched := func(h http.Handler) http.Handler {
+		return RequestIDMiddleware(AccessLogMiddleware(api.metrics.MetricsMiddleware(h)))
+	}
+	r.NotFoundHandler = unmatched(notFoundHandler())
+	r.MethodNotAllowedHandler = unmatched(methodNotAllowedHandler())
+	r.Use(RequestIDMiddleware)
+	r.Use(AccessLogMiddleware)
+	r.Use(api.metrics.MetricsMiddleware)
+	r.Use(api.AuthMiddleware)
+	r.Use(api.UserTokenMiddleware)
+	r.Use(api.IdempotencyMiddleware)
 
 	r.Handle("/metrics", promhttp.Handler())
+	r.HandleFunc("/healthz", api.GETHealthHandler).Methods("GET")
+	r.HandleFunc("/readyz", api.GETReadyHandler).Methods("GET")
 	// Define the routes and their handlers
 	r.HandleFunc("/latest", api.GETLatestHandler).Methods("GET")
 	r.HandleFunc("/register", api.RegisterHandler).Methods("POST")
@@ -829,11 +624,108 @@ func main() {
 	r.HandleFunc("/msgs", api.GETAllMessagesHandler).Methods("GET")
 	r.HandleFunc("/msgs/{username}", api.GETUserMessagesHandler).Methods("GET")
 	r.HandleFunc("/msgs/{username}", api.POSTMessagesHandler).Methods("POST")
+	r.HandleFunc("/msg/{id:[0-9]+}", api.GETMessageHandler).Methods("GET")
 	r.HandleFunc("/followingmsgs", api.GetFollowingMessages).Methods("GET")
+	r.HandleFunc("/search", api.SearchMessagesHandler).Methods("GET")
+	r.HandleFunc("/admin/msgs/flagged", api.GETFlaggedMessagesHandler).Methods("GET")
+	r.HandleFunc("/admin/msgs/{id:[0-9]+}/flag", api.FlagMessageHandler).Methods("POST")
+	r.HandleFunc("/admin/msgs/{id:[0-9]+}/unflag", api.UnflagMessageHandler).Methods("POST")
 	r.HandleFunc("/getUserDetails", api.GETUserDetailsHandler).Methods("GET")
 	r.HandleFunc("/isfollowing", api.GETFollowingHandler).Methods("GET")
 	r.HandleFunc("/login", api.PostLoginHandler).Methods("POST")
+	r.HandleFunc("/openapi.json", OpenAPIHandler(r)).Methods("GET")
+	r.HandleFunc("/docs", DocsHandler).Methods("GET")
+	r.HandleFunc("/docs/redoc.standalone.js", RedocHandler).Methods("GET")
+	return r
+}
+
+func main() {
+	initLogger()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		cfg, err := loadConfig(os.Getenv("CONFIG_FILE"))
+		if err != nil {
+			log.Fatalf("Invalid configuration:\n%v", err)
+		}
+		if err := runMigrateCommand(cfg.Database, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		cfg, err := loadConfig(os.Getenv("CONFIG_FILE"))
+		if err != nil {
+			log.Fatalf("Invalid configuration:\n%v", err)
+		}
+		if err := runAdminCommand(cfg, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	flags := flag.NewFlagSet("minitwit-api", flag.ExitOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file (CONFIG_FILE)")
+	printConfig := flags.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flags.Parse(os.Args[1:])
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if *printConfig {
+		if err := config.Write(os.Stdout, cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	level, _ := logrus.ParseLevel(cfg.LogLevel)
+	logger.SetLevel(level)
+
+	db := initDB(cfg.Database)
+
+	hasher, err := newPasswordHasher(cfg.Passwords)
+	if err != nil {
+		log.Fatalf("Failed to configure password hashing: %v", err)
+	}
+
+	tokens, err := newTokenIssuer(cfg.Tokens)
+	if err != nil {
+		log.Fatalf("Failed to configure tokens: %v", err)
+	}
+
+	commands := newCommandLog(cfg.CommandRetention)
+	gormStore := newGormStore(db)
+	commands.PruneEvery(gormStore)
+
+	metrics := InitMetrics() // Initialize metrics
+	if err := db.Use(newQueryMetrics(metrics.QueryDuration)); err != nil {
+		log.Fatalf("Failed to register query metrics: %v", err)
+	}
+	stats := newStatsCollector(gormStore)
+	stats.RefreshEvery(STATS_REFRESH)
+	prometheus.MustRegister(stats)
+	server := httpserver.New(cfg.Server)
+	server.Logf = logger.Infof
+	api := &API{metrics: metrics, hasher: hasher, credentials: loadCredentials(cfg.Auth), tokens: tokens, commands: commands, store: gormStore, pageSize: cfg.PageSize, server: server}
+
+	r := newRouter(api)
+	listener, err := net.Listen("tcp", cfg.Port)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", cfg.Port, err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	// Start the server on port 7070
-	fmt.Printf("Server starting on http://localhost%s", port)
-	log.Fatal(http.ListenAndServe(port, r))
+	fmt.Printf("Server starting on http://localhost%s\n", cfg.Port)
+	if err := server.Serve(ctx, listener, r); err != nil {
+		logger.WithError(err).Error("Server stopped with an error")
+	}
+
+	sqlDB, err := db.DB()
+	if err == nil {
+		err = sqlDB.Close()
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to close the database connections")
+	}
 }
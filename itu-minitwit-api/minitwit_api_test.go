@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"devoops/config"
+	"devoops/contract"
+	"devoops/httpserver"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Prometheus collectors can only be registered once per process
+var testMetrics = InitMetrics()
+
+// newTestAPI wires the API up on a memory store, the way main does.
+func newTestAPI(t *testing.T) *API {
+	t.Helper()
+	tokens, err := newTokenIssuer(TokenConfig{TTL: DEFAULT_TOKEN_TTL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &API{
+		metrics:     testMetrics,
+		hasher:      bcryptHasher{cost: 4},
+		credentials: Credentials{SimulatorUser: "simulator", SimulatorPassword: "secret", ServiceToken: "service"},
+		tokens:      tokens,
+		commands:    &CommandLog{retention: DEFAULT_COMMAND_RETENTION},
+		store:       newMemoryStore(),
+		pageSize:    PER_PAGE,
+		server:      httpserver.New(config.DefaultServerConfig()),
+	}
+}
+
+// openTestDB opens an empty SQLite database that is closed when the test ends.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "minitwit.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// newTestMigrator runs the schema migrations against db.
+func newTestMigrator(t *testing.T, db *gorm.DB) *Migrator {
+	t.Helper()
+	migrator, err := NewMigrator(db, schemaMigrations(defaultConfig().Database))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrator
+}
+
+// newTestGormStore migrates a fresh SQLite database, for tests of the queries themselves.
+func newTestGormStore(t *testing.T) *gormStore {
+	t.Helper()
+	db := openTestDB(t)
+	if _, err := newTestMigrator(t, db).Up(); err != nil {
+		t.Fatal(err)
+	}
+	return newGormStore(db)
+}
+
+// newTestServer runs a newTestAPI.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return newTestServerFor(t, newTestAPI(t))
+}
+
+// newTestServerFor runs api, for tests that look at its store.
+func newTestServerFor(t *testing.T, api *API) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(newRouter(api))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// simulate sends a request with the simulator credential.
+func simulate(t *testing.T, server *httptest.Server, method, path, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("simulator", "secret")
+	return send(t, req)
+}
+
+// fromFrontend sends a GET request with the frontend's service token.
+func fromFrontend(t *testing.T, server *httptest.Server, path string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("GET", server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(SERVICE_TOKEN_HEADER, "service")
+	return send(t, req)
+}
+
+func send(t *testing.T, req *http.Request) *http.Response {
+	t.Helper()
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func expectStatus(t *testing.T, resp *http.Response, status int) {
+	t.Helper()
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: got status %d, want %d", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, status)
+	}
+}
+
+func decode(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func register(t *testing.T, server *httptest.Server, username string) {
+	t.Helper()
+	body := `{"username":"` + username + `","email":"` + username + `@example.com","pwd":"pw"}`
+	expectStatus(t, simulate(t, server, "POST", "/register", body), http.StatusNoContent)
+}
+
+func TestPostAndListMessages(t *testing.T) {
+	server := newTestServer(t)
+	register(t, server, "alice")
+	register(t, server, "bob")
+
+	expectStatus(t, simulate(t, server, "POST", "/msgs/alice", `{"content":"hello"}`), http.StatusNoContent)
+	expectStatus(t, simulate(t, server, "POST", "/msgs/bob", `{"content":"hi alice"}`), http.StatusNoContent)
+	expectStatus(t, simulate(t, server, "POST", "/msgs/nobody", `{"content":"hi"}`), http.StatusNotFound)
+
+	var messages []MessageResponse
+	decode(t, simulate(t, server, "GET", "/msgs", ""), &messages)
+	if len(messages) != 2 || messages[0].User != "bob" || messages[1].Content != "hello" {
+		t.Fatalf("unexpected messages, newest first: %+v", messages)
+	}
+
+	var byAlice []MessageResponse
+	decode(t, simulate(t, server, "GET", "/msgs/alice", ""), &byAlice)
+	if len(byAlice) != 1 || byAlice[0].User != "alice" {
+		t.Fatalf("unexpected messages by alice: %+v", byAlice)
+	}
+}
+
+func TestFollowAndUnfollow(t *testing.T) {
+	server := newTestServer(t)
+	register(t, server, "alice")
+	register(t, server, "bob")
+
+	statuses := []struct {
+		body, status string
+		code         int
+	}{
+		{`{"follow":"bob"}`, FOLLOW_STATUS_FOLLOWED, http.StatusOK},
+		{`{"follow":"bob"}`, FOLLOW_STATUS_ALREADY_FOLLOWING, http.StatusOK},
+	}
+	for _, s := range statuses {
+		resp := simulate(t, server, "POST", "/fllws/alice", s.body)
+		expectStatus(t, resp, s.code)
+		var response FollowResponse
+		decode(t, resp, &response)
+		if response.Status != s.status {
+			t.Fatalf("%s: got status %q, want %q", s.body, response.Status, s.status)
+		}
+	}
+
+	resp := simulate(t, server, "POST", "/fllws/alice", `{"follow":"alice"}`)
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+	var selfFollow APIError
+	decode(t, resp, &selfFollow)
+	if selfFollow.Code != ERR_CANNOT_FOLLOW_SELF {
+		t.Fatalf("unexpected error response %+v", selfFollow)
+	}
+
+	var follows FollowsResponse
+	decode(t, simulate(t, server, "GET", "/fllws/alice", ""), &follows)
+	if len(follows.Follows) != 1 || follows.Follows[0] != "bob" {
+		t.Fatalf("unexpected follows: %+v", follows.Follows)
+	}
+
+	var response FollowResponse
+	decode(t, simulate(t, server, "POST", "/fllws/alice", `{"unfollow":"bob"}`), &response)
+	if response.Status != FOLLOW_STATUS_UNFOLLOWED {
+		t.Fatalf("got status %q, want %q", response.Status, FOLLOW_STATUS_UNFOLLOWED)
+	}
+	decode(t, simulate(t, server, "POST", "/fllws/alice", `{"unfollow":"bob"}`), &response)
+	if response.Status != FOLLOW_STATUS_NOT_FOLLOWING {
+		t.Fatalf("got status %q, want %q", response.Status, FOLLOW_STATUS_NOT_FOLLOWING)
+	}
+}
+
+func TestGetMessage(t *testing.T) {
+	server := newTestServer(t)
+	register(t, server, "alice")
+	expectStatus(t, simulate(t, server, "POST", "/msgs/alice", `{"content":"hello"}`), http.StatusNoContent)
+
+	var messages []MessageResponse
+	decode(t, simulate(t, server, "GET", "/msgs", ""), &messages)
+
+	var message MessageResponse
+	decode(t, fromFrontend(t, server, fmt.Sprintf("/msg/%d", messages[0].MessageID)), &message)
+	if message.Content != "hello" || message.User != "alice" {
+		t.Fatalf("unexpected message: %+v", message)
+	}
+	expectStatus(t, fromFrontend(t, server, "/msg/99"), http.StatusNotFound)
+}
+
+func TestInvalidRequestBodies(t *testing.T) {
+	server := newTestServer(t)
+	register(t, server, "alice")
+
+	cases := []struct {
+		path, body string
+		status     int
+		field      string
+	}{
+		{"/register", `{"username":"","email":"a@a","pwd":"pw"}`, http.StatusBadRequest, "username"},
+		{"/register", `{"username":"alice","email":"a@a","pwd":"pw"}`, http.StatusBadRequest, "username"},
+		{"/nowhere", `{}`, http.StatusNotFound, ""},
+		{"/register", `{"username":"bob","email":"nope","pwd":"pw"}`, http.StatusBadRequest, "email"},
+		{"/register", `{"username":"` + strings.Repeat("b", contract.MAX_USERNAME_LENGTH+1) + `","email":"b@b","pwd":"pw"}`, http.StatusBadRequest, "username"},
+		{"/register", `{"username":7}`, http.StatusBadRequest, "username"},
+		{"/msgs/alice", `{"content":"hi","extra":true}`, http.StatusBadRequest, "extra"},
+		{"/msgs/alice", `{"content":"  "}`, http.StatusBadRequest, "content"},
+		{"/msgs/alice", `{"content":`, http.StatusBadRequest, ""},
+		{"/msgs/alice", `{"content":"` + strings.Repeat("x", MAX_REQUEST_BODY) + `"}`, http.StatusRequestEntityTooLarge, ""},
+		{"/fllws/alice", `{}`, http.StatusBadRequest, "follow"},
+		{"/fllws/alice", `{"follow":"alice","unfollow":"alice"}`, http.StatusBadRequest, "unfollow"},
+	}
+	for _, c := range cases {
+		resp := simulate(t, server, "POST", c.path, c.body)
+		expectStatus(t, resp, c.status)
+		var response APIError
+		decode(t, resp, &response)
+		if response.Status != c.status || response.Code == "" || response.ErrorMsg == "" ||
+			response.RequestID != resp.Header.Get(REQUEST_ID_HEADER) {
+			t.Fatalf("%s %.40s: unexpected error response %+v", c.path, c.body, response)
+		}
+		if _, found := response.Fields[c.field]; c.field != "" && !found {
+			t.Fatalf("%s %.40s: expected an error for field %q, got %+v", c.path, c.body, c.field, response.Fields)
+		}
+	}
+}
+
+func TestCheckEncodeResponse(t *testing.T) {
+	cases := []struct {
+		response interface{}
+		status   int
+		want     int
+		body     bool
+	}{
+		{map[string]int{"latest": 1}, http.StatusOK, http.StatusOK, true},
+		{map[string]int{"ignored": 1}, http.StatusNoContent, http.StatusNoContent, false},
+		{nil, http.StatusNotModified, http.StatusNotModified, false},
+		{func() {}, http.StatusOK, http.StatusInternalServerError, true}, // cannot be encoded
+	}
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		w := &responseWriter{ResponseWriter: recorder}
+		CheckEncodeResponse(w, c.response, c.status)
+		CheckEncodeResponse(w, nil, http.StatusTeapot) // headers are out, must be ignored
+
+		if recorder.Code != c.want {
+			t.Fatalf("status %d: got %d, want %d", c.status, recorder.Code, c.want)
+		}
+		if hasBody := recorder.Body.Len() > 0; hasBody != c.body {
+			t.Fatalf("status %d: got body %q", c.status, recorder.Body.String())
+		}
+	}
+}
+
+func TestRequestMetricsUseRouteTemplates(t *testing.T) {
+	server := newTestServer(t)
+	register(t, server, "alice")
+
+	posted := testMetrics.Requests.WithLabelValues("/msgs/{username}", "POST", "204")
+	missing := testMetrics.Requests.WithLabelValues(UNMATCHED_ROUTE, "POST", "404")
+	before, beforeMissing := testutil.ToFloat64(posted), testutil.ToFloat64(missing)
+
+	expectStatus(t, simulate(t, server, "POST", "/msgs/alice", `{"content":"hello"}`), http.StatusNoContent)
+	expectStatus(t, simulate(t, server, "POST", "/nowhere/1", `{}`), http.StatusNotFound)
+
+	if got := testutil.ToFloat64(posted) - before; got != 1 {
+		t.Fatalf("counted %v posted messages, want 1", got)
+	}
+	if got := testutil.ToFloat64(missing) - beforeMissing; got != 1 {
+		t.Fatalf("counted %v unmatched requests, want 1", got)
+	}
+	if got := testutil.ToFloat64(testMetrics.RequestsInFlight.WithLabelValues("/msgs/{username}")); got != 0 {
+		t.Fatalf("%v requests still in flight", got)
+	}
+}
+
+func TestStatsCollector(t *testing.T) {
+	store := newMemoryStore()
+	stats := newStatsCollector(store)
+	stats.refresh()
+
+	alice, bob := User{Username: "alice"}, User{Username: "bob"}
+	for _, user := range []*User{&alice, &bob} {
+		if err := store.CreateUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.CreateMessage(&Message{AuthorID: alice.UserID, Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Follow(alice.UserID, bob.UserID); err != nil {
+		t.Fatal(err)
+	}
+	store.StoreLatest(42)
+	stats.refresh()
+
+	expected := `
+# HELP minitwit_users Number of users in the database
+# TYPE minitwit_users gauge
+minitwit_users 2
+# HELP minitwit_users_growth Change in the number of users since the previous count
+# TYPE minitwit_users_growth gauge
+minitwit_users_growth 2
+# HELP minitwit_follows Number of follows in the database
+# TYPE minitwit_follows gauge
+minitwit_follows 1
+# HELP minitwit_simulator_latest Latest action id sent by the simulator
+# TYPE minitwit_simulator_latest gauge
+minitwit_simulator_latest 42
+`
+	err := testutil.CollectAndCompare(stats, strings.NewReader(expected),
+		"minitwit_users", "minitwit_users_growth", "minitwit_follows", "minitwit_simulator_latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	server := newTestServer(t)
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := send(t, req) // without credentials
+		expectStatus(t, resp, http.StatusOK)
+		var health HealthResponse
+		decode(t, resp, &health)
+		if health.Status != HEALTH_OK {
+			t.Fatalf("%s: unexpected response %+v", path, health)
+		}
+		if _, found := health.Checks["database"]; path == "/readyz" && !found {
+			t.Fatalf("%s: database was not checked: %+v", path, health)
+		}
+	}
+}
+
+// decodeContract decodes a response into its contract type and fails if the response has
+// fields the contract does not know, or misses fields the contract sends.
+func decodeContract(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		t.Fatalf("%s %s does not match the contract: %v\n%s", resp.Request.Method, resp.Request.URL.Path, err, body)
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want interface{}
+	json.Unmarshal(body, &got)
+	json.Unmarshal(encoded, &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s %s does not match the contract:\ngot  %s\nwant %s", resp.Request.Method, resp.Request.URL.Path, body, encoded)
+	}
+}
+
+func TestResponsesMatchContract(t *testing.T) {
+	server := newTestServer(t)
+	register(t, server, "alice")
+	register(t, server, "bob")
+	expectStatus(t, simulate(t, server, "POST", "/msgs/alice?latest=3", `{"content":"hello"}`), http.StatusNoContent)
+
+	var messages []contract.Message
+	decodeContract(t, simulate(t, server, "GET", "/msgs", ""), &messages)
+	if len(messages) != 1 || messages[0].User != "alice" || messages[0].PubDate.IsZero() || messages[0].AuthorID == 0 {
+		t.Fatalf("unexpected messages %+v", messages)
+	}
+	decodeContract(t, fromFrontend(t, server, fmt.Sprintf("/msg/%d", messages[0].MessageID)), &contract.Message{})
+
+	var user contract.UserDetails
+	decodeContract(t, fromFrontend(t, server, "/getUserDetails?username=alice"), &user)
+	if user.UserID == 0 || user.Username != "alice" || user.Email == "" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	var follow contract.FollowResponse
+	decodeContract(t, simulate(t, server, "POST", "/fllws/alice", `{"follow":"bob"}`), &follow)
+	decodeContract(t, simulate(t, server, "GET", "/fllws/alice", ""), &contract.FollowsResponse{})
+
+	var latest contract.LatestResponse
+	decodeContract(t, simulate(t, server, "GET", "/latest", ""), &latest)
+	if latest.Latest != 3 {
+		t.Fatalf("got latest %d, want 3", latest.Latest)
+	}
+
+	var apiErr contract.APIError
+	decodeContract(t, simulate(t, server, "POST", "/register", `{}`), &apiErr)
+	if apiErr.Code != contract.ERR_VALIDATION || apiErr.Fields["username"] == "" {
+		t.Fatalf("unexpected error %+v", apiErr)
+	}
+	decodeContract(t, fromFrontend(t, server, "/readyz"), &contract.HealthResponse{})
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	server := newTestServer(t)
+	req, err := http.NewRequest("GET", server.URL+"/openapi.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := send(t, req) // without credentials
+	expectStatus(t, resp, http.StatusOK)
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	decode(t, resp, &spec)
+	if spec.OpenAPI != OPENAPI_VERSION {
+		t.Fatalf("unexpected openapi version %q", spec.OpenAPI)
+	}
+
+	registered := map[string]bool{}
+	err = newRouter(&API{metrics: testMetrics}).Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{"GET"}
+		}
+		path, _ := openAPIPath(template)
+		for _, method := range methods {
+			registered[method+" "+template] = true
+			if _, found := spec.Paths[path][strings.ToLower(method)]; !found {
+				t.Errorf("%s %s is missing from the OpenAPI document", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for route := range openAPIRoutes {
+		if !registered[route] {
+			t.Errorf("%s is documented but not registered", route)
+		}
+	}
+}
+
+func TestDocsLoadRedocFromTheAPI(t *testing.T) {
+	server := newTestServer(t)
+	get := func(path string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return send(t, req) // without credentials
+	}
+
+	resp := get("/docs")
+	expectStatus(t, resp, http.StatusOK)
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(page), "https://") || !strings.Contains(string(page), `src="/`+REDOC_BUNDLE+`"`) {
+		t.Fatalf("docs page loads scripts from elsewhere:\n%s", page)
+	}
+	expectStatus(t, get("/"+REDOC_BUNDLE), http.StatusNotFound)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(REDOC_BUNDLE)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, REDOC_BUNDLE), []byte("/* redoc */"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	resp = get("/" + REDOC_BUNDLE)
+	expectStatus(t, resp, http.StatusOK)
+	if bundle, err := io.ReadAll(resp.Body); err != nil || string(bundle) != "/* redoc */" {
+		t.Fatalf("unexpected bundle %q, %v", bundle, err)
+	}
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	api := newTestAPI(t)
+	cfg := config.DefaultServerConfig()
+	cfg.PreShutdownDelay = 200 * time.Millisecond
+	api.server = httpserver.New(cfg)
+	router := newRouter(api)
+	started, release := make(chan struct{}), make(chan struct{})
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- api.server.Serve(ctx, listener, router) }()
+
+	answered := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", "http://"+listener.Addr().String()+"/slow", nil)
+		req.Header.Set(SERVICE_TOKEN_HEADER, "service")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			answered <- 0
+			return
+		}
+		resp.Body.Close()
+		answered <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+
+	// Readiness reports down to probes before the listener closes
+	for !api.server.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	resp, err := http.Get("http://" + listener.Addr().String() + "/readyz")
+	if err != nil {
+		t.Fatalf("readiness probe during the pre-shutdown delay: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("readiness while draining: got status %d", resp.StatusCode)
+	}
+
+	close(release)
+	if status := <-answered; status != http.StatusOK {
+		t.Fatalf("in-flight request: got status %d", status)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.yaml")
+	file := "port: \":7070\"\npage_size: 50\ndatabase:\n  path: /data/minitwit.db\nauth:\n  service_token: from-file\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SERVICE_TOKEN", "from-env")
+	t.Setenv("TOKEN_SECRET", "token-secret")
+	t.Setenv("TOKEN_TTL", "1h")
+	t.Setenv("LATEST_FILE", "/data/latest.txt")
+	t.Setenv("ADMIN_USER", "root")
+	t.Setenv("ADMIN_PASSWORD", "admin-secret")
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != ":7070" || cfg.PageSize != 50 || cfg.Database.Path != "/data/minitwit.db" ||
+		cfg.Auth.ServiceToken != "from-env" || cfg.Tokens.TTL != time.Hour || cfg.Passwords.Hasher != "bcrypt" ||
+		cfg.Database.LegacyLatestFile != "/data/latest.txt" || cfg.Admin.User != "root" || cfg.Admin.Password != "admin-secret" {
+		t.Fatalf("unexpected configuration %+v", cfg)
+	}
+
+	var out strings.Builder
+	if err := config.Write(&out, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "from-env") || strings.Contains(out.String(), "super_safe!") ||
+		strings.Contains(out.String(), "admin-secret") || strings.Contains(out.String(), "token-secret") {
+		t.Fatalf("secrets were printed:\n%s", out.String())
+	}
+
+	// Every invalid setting is reported at once
+	t.Setenv("PAGE_SIZE", "0")
+	t.Setenv("PASSWORD_HASHER", "md5")
+	_, err = loadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "PAGE_SIZE") || !strings.Contains(err.Error(), "PASSWORD_HASHER") {
+		t.Fatalf("expected PAGE_SIZE and PASSWORD_HASHER errors, got %v", err)
+	}
+}
+
+// Outside dev mode the shared secrets are required, as the frontend cannot call the API
+// without SERVICE_TOKEN and a random TOKEN_SECRET logs every user out on a restart.
+func TestLoadConfigRequiresSecretsOutsideDevMode(t *testing.T) {
+	t.Setenv("SERVICE_TOKEN", "")
+	t.Setenv("TOKEN_SECRET", "")
+	_, err := loadConfig("")
+	if err == nil || !strings.Contains(err.Error(), "SERVICE_TOKEN") || !strings.Contains(err.Error(), "TOKEN_SECRET") {
+		t.Fatalf("expected SERVICE_TOKEN and TOKEN_SECRET errors, got %v", err)
+	}
+
+	t.Setenv("MODE", config.MODE_DEV)
+	if _, err := loadConfig(""); err != nil {
+		t.Fatalf("dev mode without secrets: %v", err)
+	}
+
+	t.Setenv("MODE", "staging")
+	if _, err := loadConfig(""); err == nil || !strings.Contains(err.Error(), "MODE") {
+		t.Fatalf("expected a MODE error, got %v", err)
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	server := newTestServer(t)
+	for id, kept := range map[string]bool{"frontend-1": true, "with space": false, "": false} {
+		req, err := http.NewRequest("GET", server.URL+"/healthz", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(REQUEST_ID_HEADER, id)
+		got := send(t, req).Header.Get(REQUEST_ID_HEADER)
+		if (got == id) != kept || !contract.ValidRequestID(got) {
+			t.Errorf("%q: got request id %q", id, got)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// moderate handles both flagging and unflagging a message.
+func (api *API) moderate(w http.ResponseWriter, r *http.Request, action string) {
+	moderator, _ := api.credentials.isAdmin(r)
+	messageID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		api.metrics.BadRequests.WithLabelValues("moderation").Inc()
+		writeError(w, r, newAPIError(http.StatusBadRequest, ERR_BAD_REQUEST, "Invalid message id"))
+		return
+	}
+
+	// The reason is optional, an empty body is fine
+	var data FlagRequest
+	if !decodeRequest(w, r, &data) {
+		api.metrics.BadRequests.WithLabelValues("moderation").Inc()
+		return
+	}
+
+	err = api.store.SetFlag(uint(messageID), action, moderator, data.Reason)
+	if errors.Is(err, ErrNotFound) {
+		api.metrics.BadRequests.WithLabelValues("moderation").Inc()
+		writeError(w, r, newAPIError(http.StatusNotFound, ERR_MESSAGE_NOT_FOUND, "Cannot find message"))
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to update message flag")
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Failed to update message"))
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"message_id": messageID,
+		"action":     action,
+		"moderator":  moderator,
+		"reason":     data.Reason,
+	}).Info("Message moderated")
+	api.metrics.SuccessfulRequests.WithLabelValues("moderation").Inc()
+
+	CheckEncodeResponse(w, FlagResponse{MessageID: uint(messageID), Flagged: action == FLAG_ACTION_FLAG}, http.StatusOK)
+}
+
+func (api *API) FlagMessageHandler(w http.ResponseWriter, r *http.Request) {
+	api.moderate(w, r, FLAG_ACTION_FLAG)
+}
+
+func (api *API) UnflagMessageHandler(w http.ResponseWriter, r *http.Request) {
+	api.moderate(w, r, FLAG_ACTION_UNFLAG)
+}
+
+// GETFlaggedMessagesHandler lists flagged messages, newest first, with the latest flag
+// recorded for each. Messages flagged before moderation was recorded have no moderator.
+func (api *API) GETFlaggedMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r, CURSOR_MESSAGE, DEFAULT_NO)
+	if err != nil {
+		api.metrics.BadRequests.WithLabelValues("moderation").Inc()
+		writeError(w, r, newAPIError(http.StatusBadRequest, ERR_BAD_REQUEST, err.Error()))
+		return
+	}
+
+	messages, err := api.store.ListFlagged(page)
+	if err != nil {
+		logger.WithError(err).Error("Failed to fetch flagged messages")
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Query execution failed"))
+		return
+	}
+
+	messages, cursors := paginate(page, CURSOR_MESSAGE, messages, func(m FlaggedMessage) uint { return m.MessageID })
+	if messages == nil {
+		messages = []FlaggedMessage{}
+	}
+	api.metrics.SuccessfulRequests.WithLabelValues("moderation").Inc()
+
+	setPageHeaders(w, r, cursors)
+	CheckEncodeResponse(w, messages, http.StatusOK)
+}
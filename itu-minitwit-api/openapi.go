@@ -0,0 +1,542 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const OPENAPI_VERSION = "3.0.3"
+
+// openAPIText marks a response that is plain text rather than JSON.
+type openAPIText string
+
+type openAPIParam struct {
+	Name        string
+	Type        string // string, integer or boolean
+	Required    bool
+	Description string
+}
+
+// openAPIRoute documents a route of newRouter. Responses maps the success and handler
+// specific statuses to an example of their body, nil when there is none. The errors every
+// route of its kind can answer with are added by buildOpenAPI.
+type openAPIRoute struct {
+	Summary   string
+	Query     []openAPIParam
+	Paged     bool // accepts ?no=, ?before= and ?after= and answers with cursor headers
+	Body      interface{}
+	Optional  bool // the body may be left out
+	Responses map[int]interface{}
+}
+
+var userIDParam = openAPIParam{Name: "userid", Type: "integer", Required: true, Description: "Id of the user whose timeline is read"}
+
+// openAPIRoutes documents every route, keyed by method and path template like userRoutes.
+var openAPIRoutes = map[string]openAPIRoute{
+	"GET /metrics": {
+		Summary:   "Prometheus metrics",
+		Responses: map[int]interface{}{http.StatusOK: openAPIText("")},
+	},
+	"GET /healthz": {
+		Summary:   "Liveness probe",
+		Responses: map[int]interface{}{http.StatusOK: HealthResponse{}},
+	},
+	"GET /readyz": {
+		Summary: "Readiness probe, checks the database",
+		Responses: map[int]interface{}{
+			http.StatusOK:                 HealthResponse{},
+			http.StatusServiceUnavailable: HealthResponse{},
+		},
+	},
+	"GET /openapi.json": {
+		Summary:   "This document",
+		Responses: map[int]interface{}{http.StatusOK: map[string]interface{}{}},
+	},
+	"GET /docs": {
+		Summary:   "Documentation page rendering this document",
+		Responses: map[int]interface{}{http.StatusOK: openAPIText("")},
+	},
+	"GET /docs/redoc.standalone.js": {
+		Summary: "Script of the documentation page",
+		Responses: map[int]interface{}{
+			http.StatusOK:       openAPIText(""),
+			http.StatusNotFound: APIError{},
+		},
+	},
+	"GET /latest": {
+		Summary:   "Latest simulator action id",
+		Responses: map[int]interface{}{http.StatusCreated: LatestResponse{}},
+	},
+	"POST /register": {
+		Summary: "Register a user",
+		Body:    RegisterRequest{},
+		Responses: map[int]interface{}{
+			http.StatusNoContent:  nil,
+			http.StatusBadRequest: APIError{},
+		},
+	},
+	"POST /fllws/{username}": {
+		Summary: "Follow or unfollow a user",
+		Body:    FollowRequest{},
+		Responses: map[int]interface{}{
+			http.StatusOK:                  FollowResponse{},
+			http.StatusNotFound:            APIError{},
+			http.StatusUnprocessableEntity: APIError{},
+		},
+	},
+	"GET /fllws/{username}": {
+		Summary: "Users followed by a user",
+		Paged:   true,
+		Responses: map[int]interface{}{
+			http.StatusOK:       FollowsResponse{},
+			http.StatusNotFound: APIError{},
+		},
+	},
+	"GET /msgs": {
+		Summary:   "Latest unflagged messages",
+		Paged:     true,
+		Responses: map[int]interface{}{http.StatusOK: []MessageResponse{}},
+	},
+	"GET /msgs/{username}": {
+		Summary: "Messages of a user",
+		Paged:   true,
+		Responses: map[int]interface{}{
+			http.StatusOK:       []MessageResponse{},
+			http.StatusNotFound: APIError{},
+		},
+	},
+	"POST /msgs/{username}": {
+		Summary: "Post a message as a user",
+		Body:    MessageRequest{},
+		Responses: map[int]interface{}{
+			http.StatusNoContent: nil,
+			http.StatusNotFound:  APIError{},
+		},
+	},
+	"GET /msg/{id:[0-9]+}": {
+		Summary: "A single message",
+		Responses: map[int]interface{}{
+			http.StatusOK:       MessageResponse{},
+			http.StatusNotFound: APIError{},
+		},
+	},
+	"GET /followingmsgs": {
+		Summary: "Timeline of a user and the users they follow",
+		Query:   []openAPIParam{userIDParam},
+		Paged:   true,
+		Responses: map[int]interface{}{
+			http.StatusOK:         []MessageResponse{},
+			http.StatusBadRequest: APIError{},
+		},
+	},
+	"GET /search": {
+		Summary: "Search messages",
+		Query:   []openAPIParam{{Name: "q", Type: "string", Required: true, Description: "Search terms, all of them must match"}},
+		Paged:   true,
+		Responses: map[int]interface{}{
+			http.StatusOK:         []MessageResponse{},
+			http.StatusBadRequest: APIError{},
+		},
+	},
+	"GET /admin/msgs/flagged": {
+		Summary:   "Flagged messages with their latest flag",
+		Paged:     true,
+		Responses: map[int]interface{}{http.StatusOK: []FlaggedMessage{}},
+	},
+	"POST /admin/msgs/{id:[0-9]+}/flag": {
+		Summary:  "Flag a message",
+		Body:     FlagRequest{},
+		Optional: true,
+		Responses: map[int]interface{}{
+			http.StatusOK:       FlagResponse{},
+			http.StatusNotFound: APIError{},
+		},
+	},
+	"POST /admin/msgs/{id:[0-9]+}/unflag": {
+		Summary:  "Unflag a message",
+		Body:     FlagRequest{},
+		Optional: true,
+		Responses: map[int]interface{}{
+			http.StatusOK:       FlagResponse{},
+			http.StatusNotFound: APIError{},
+		},
+	},
+	"GET /getUserDetails": {
+		Summary: "Details of a user, by id or by username",
+		Query: []openAPIParam{
+			{Name: "user_id", Type: "integer", Description: "Id of the user"},
+			{Name: "username", Type: "string", Description: "Name of the user, used when user_id is not set"},
+		},
+		Responses: map[int]interface{}{
+			http.StatusOK:         UserDetails{},
+			http.StatusBadRequest: APIError{},
+			http.StatusNotFound:   APIError{},
+		},
+	},
+	"GET /isfollowing": {
+		Summary: "Whether a user follows another",
+		Query: []openAPIParam{
+			{Name: "whoUsername", Type: "string", Required: true, Description: "The follower"},
+			{Name: "whomUsername", Type: "string", Required: true, Description: "The followed user"},
+		},
+		Responses: map[int]interface{}{http.StatusOK: true},
+	},
+	"POST /login": {
+		Summary: "Issue a user token",
+		Body:    LoginRequest{},
+		Responses: map[int]interface{}{
+			http.StatusOK:           LoginResponse{},
+			http.StatusUnauthorized: APIError{},
+			http.StatusNotFound:     APIError{},
+		},
+	},
+}
+
+// openAPISecurity lists the security schemes used by the routes.
+var openAPISecurity = map[string]interface{}{
+	"simulatorAuth": map[string]interface{}{"type": "http", "scheme": "basic", "description": "The simulator credential"},
+	"adminAuth":     map[string]interface{}{"type": "http", "scheme": "basic", "description": "A moderator from ADMIN_USERS"},
+	"serviceToken":  map[string]interface{}{"type": "apiKey", "in": "header", "name": SERVICE_TOKEN_HEADER, "description": "The frontend's shared secret"},
+	"userToken":     map[string]interface{}{"type": "http", "scheme": "bearer", "description": "A token issued by POST /login"},
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// openAPIPath turns a mux path template into an OpenAPI path and its parameter names.
+func openAPIPath(template string) (string, []string) {
+	var names []string
+	path := pathParamPattern.ReplaceAllStringFunc(template, func(param string) string {
+		name := pathParamPattern.FindStringSubmatch(param)[1]
+		names = append(names, name)
+		return "{" + name + "}"
+	})
+	return path, names
+}
+
+// openAPISchemas builds JSON schemas from Go types and collects the named ones as
+// components.
+type openAPISchemas map[string]interface{}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (s openAPISchemas) schemaOf(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(openAPIText("")):
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := s.schemaOf(t.Elem())
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schemaOf(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if _, found := s[t.Name()]; !found {
+			s[t.Name()] = nil // placeholder, so recursive types terminate
+			s[t.Name()] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	panic(fmt.Sprintf("openapi: unsupported type %s", t))
+}
+
+// structSchema describes the JSON encoding of a struct: fields are named after their
+// json tag and required unless they are omitempty.
+func (s openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.schemaOf(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (s openAPISchemas) content(body interface{}) map[string]interface{} {
+	mediaType := "application/json"
+	switch body.(type) {
+	case openAPIText:
+		mediaType = "text/plain"
+	}
+	return map[string]interface{}{
+		mediaType: map[string]interface{}{"schema": s.schemaOf(reflect.TypeOf(body))},
+	}
+}
+
+func queryParameter(p openAPIParam) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        p.Name,
+		"in":          "query",
+		"required":    p.Required,
+		"description": p.Description,
+		"schema":      map[string]interface{}{"type": p.Type},
+	}
+}
+
+// routeSecurity lists the credentials AuthMiddleware and UserTokenMiddleware accept for
+// a route, any one entry of the list is enough.
+func routeSecurity(method, template string) []interface{} {
+	scheme := func(names ...string) map[string]interface{} {
+		requirement := map[string]interface{}{}
+		for _, name := range names {
+			requirement[name] = []string{}
+		}
+		return requirement
+	}
+	switch {
+	case publicRoutes[template]:
+		return []interface{}{}
+	case userRoutes[method+" "+template]:
+		return []interface{}{scheme("simulatorAuth"), scheme("serviceToken", "userToken")}
+	case simulatorRoutes[template]:
+		return []interface{}{scheme("simulatorAuth"), scheme("serviceToken")}
+	case adminRoutes[template]:
+		return []interface{}{scheme("adminAuth")}
+	}
+	return []interface{}{scheme("serviceToken")}
+}
+
+// operation documents a single route, adding the errors its middleware can answer with.
+func (s openAPISchemas) operation(method, template string, doc openAPIRoute, pathParams []string) map[string]interface{} {
+	parameters := []interface{}{}
+	for _, name := range pathParams {
+		parameters = append(parameters, map[string]interface{}{
+			"name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, p := range doc.Query {
+		parameters = append(parameters, queryParameter(p))
+	}
+	if doc.Paged {
+		parameters = append(parameters,
+			queryParameter(openAPIParam{Name: "no", Type: "integer", Description: fmt.Sprintf("Page size, at most %d", MAX_PAGE_SIZE)}),
+			queryParameter(openAPIParam{Name: "before", Type: "string", Description: "Cursor of the next page, towards older rows"}),
+			queryParameter(openAPIParam{Name: "after", Type: "string", Description: "Cursor of the previous page, towards newer rows"}),
+		)
+	}
+	if simulatorRoutes[template] {
+		parameters = append(parameters, queryParameter(openAPIParam{Name: "latest", Type: "integer", Description: "Id of the simulator action, reported by GET /latest"}))
+	}
+
+	statuses := map[int]interface{}{}
+	for status, body := range doc.Responses {
+		statuses[status] = body
+	}
+	if !publicRoutes[template] {
+		statuses[http.StatusForbidden] = APIError{}
+		statuses[http.StatusInternalServerError] = APIError{}
+	}
+	if userRoutes[method+" "+template] {
+		statuses[http.StatusUnauthorized] = APIError{}
+	}
+	if commandRoutes[method+" "+template] {
+		statuses[http.StatusConflict] = APIError{}
+	}
+	if doc.Body != nil {
+		statuses[http.StatusBadRequest] = APIError{}
+		statuses[http.StatusRequestEntityTooLarge] = APIError{}
+	}
+
+	responses := map[string]interface{}{}
+	for status, body := range statuses {
+		response := map[string]interface{}{"description": http.StatusText(status)}
+		if body != nil {
+			response["content"] = s.content(body)
+		}
+		if doc.Paged && status == http.StatusOK {
+			response["headers"] = map[string]interface{}{
+				"X-Next-Cursor": map[string]interface{}{"description": "Cursor of the next page", "schema": map[string]interface{}{"type": "string"}},
+				"X-Prev-Cursor": map[string]interface{}{"description": "Cursor of the previous page", "schema": map[string]interface{}{"type": "string"}},
+				"Link":          map[string]interface{}{"description": "Links to the neighbouring pages", "schema": map[string]interface{}{"type": "string"}},
+			}
+		}
+		responses[fmt.Sprint(status)] = response
+	}
+
+	op := map[string]interface{}{
+		"summary":     doc.Summary,
+		"operationId": operationID(method, template),
+		"security":    routeSecurity(method, template),
+		"responses":   responses,
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+	if doc.Body != nil {
+		op["requestBody"] = map[string]interface{}{"required": !doc.Optional, "content": s.content(doc.Body)}
+	}
+	return op
+}
+
+// operationID derives a stable id from the method and path, GET /msgs/{username}
+// becomes getMsgsByUsername.
+func operationID(method, template string) string {
+	path, _ := openAPIPath(template)
+	id := strings.ToLower(method)
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '.' }) {
+		if strings.HasPrefix(part, "{") {
+			id += "By"
+			part = strings.Trim(part, "{}")
+		}
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// buildOpenAPI documents every route registered on r. It fails on routes missing from
+// openAPIRoutes, so a new route cannot be added without documenting it.
+func buildOpenAPI(r *mux.Router) (map[string]interface{}, error) {
+	schemas := openAPISchemas{}
+	paths := map[string]interface{}{}
+	var undocumented []string
+
+	err := r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{"GET"} // routes without a method, like /metrics, are read with GET
+		}
+		path, pathParams := openAPIPath(template)
+		item, _ := paths[path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		for _, method := range methods {
+			doc, found := openAPIRoutes[method+" "+template]
+			if !found {
+				undocumented = append(undocumented, method+" "+template)
+				continue
+			}
+			item[strings.ToLower(method)] = schemas.operation(method, template, doc, pathParams)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(undocumented) > 0 {
+		sort.Strings(undocumented)
+		return nil, fmt.Errorf("routes missing from openAPIRoutes: %s", strings.Join(undocumented, ", "))
+	}
+
+	return map[string]interface{}{
+		"openapi": OPENAPI_VERSION,
+		"info": map[string]interface{}{
+			"title":       "MiniTwit API",
+			"version":     "1.0.0",
+			"description": "API used by the MiniTwit frontend and the course simulator. Failed requests answer with an APIError.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas":         map[string]interface{}(schemas),
+			"securitySchemes": openAPISecurity,
+		},
+	}, nil
+}
+
+// OpenAPIHandler serves the document of r. It is built on the first request, once every
+// route has been registered.
+func OpenAPIHandler(r *mux.Router) http.HandlerFunc {
+	var once sync.Once
+	var document []byte
+	var buildErr error
+	return func(w http.ResponseWriter, req *http.Request) {
+		once.Do(func() {
+			var spec map[string]interface{}
+			if spec, buildErr = buildOpenAPI(r); buildErr == nil {
+				document, buildErr = json.Marshal(spec)
+			}
+		})
+		if buildErr != nil {
+			requestLogger(req).WithError(buildErr).Error("Failed to build the OpenAPI document")
+			writeError(w, req, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Failed to build the API documentation"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	}
+}
+
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>MiniTwit API</title>
+  <meta charset="utf-8">
+</head>
+<body>
+  <redoc spec-url="/openapi.json"></redoc>
+  <script src="/docs/redoc.standalone.js"></script>
+</body>
+</html>
+`
+
+// DocsHandler serves a page rendering /openapi.json.
+func DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, docsPage)
+}
+
+// REDOC_BUNDLE is the Redoc script the docs page loads. The dockerfiles copy a pinned
+// release from the npm registry into the image, so /docs loads nothing from a CDN.
+const REDOC_BUNDLE = "docs/redoc.standalone.js"
+
+// RedocHandler serves REDOC_BUNDLE, relative to the working directory.
+func RedocHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := os.Stat(REDOC_BUNDLE); err != nil {
+		writeError(w, r, newAPIError(http.StatusNotFound, ERR_NOT_FOUND, "The documentation bundle is not part of this build"))
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, REDOC_BUNDLE)
+}
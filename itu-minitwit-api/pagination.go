@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Cursor kinds, so a cursor from one listing cannot be replayed against another.
+const (
+	CURSOR_MESSAGE = "msg"
+	CURSOR_USER    = "user"
+)
+
+// MAX_PAGE_SIZE caps ?no=, larger values are clamped to it.
+const MAX_PAGE_SIZE = 1000
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page describes the window requested with ?before=, ?after= and ?no=. Listings are
+// ordered newest first, so before pages towards older rows and after towards newer ones.
+type Page struct {
+	Before uint
+	After  uint
+	Limit  int
+}
+
+// Cursors point at the neighbouring pages, empty when there is nothing to page to.
+type Cursors struct {
+	Next string // older rows
+	Prev string // newer rows
+}
+
+func encodeCursor(kind string, id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d", kind, id)))
+}
+
+func decodeCursor(kind, cursor string) (uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	prefix, value, found := strings.Cut(string(raw), ":")
+	if !found || prefix != kind {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return uint(id), nil
+}
+
+// parsePage reads the paging parameters of a request. ?no= keeps its meaning as the
+// page size, up to MAX_PAGE_SIZE.
+func parsePage(r *http.Request, kind string, defaultLimit int) (Page, error) {
+	page := Page{Limit: defaultLimit}
+	if number := r.URL.Query().Get("no"); number != "" {
+		if n, err := strconv.Atoi(number); err == nil && n > 0 {
+			page.Limit = min(n, MAX_PAGE_SIZE)
+		}
+	}
+
+	before, after := r.URL.Query().Get("before"), r.URL.Query().Get("after")
+	if before != "" && after != "" {
+		return page, errors.New("before and after cannot be combined")
+	}
+
+	var err error
+	if before != "" {
+		page.Before, err = decodeCursor(kind, before)
+	} else if after != "" {
+		page.After, err = decodeCursor(kind, after)
+	}
+	return page, err
+}
+
+// Apply restricts a query to the page, ordering on the given id column. One extra row is
+// fetched to tell whether there is another page.
+func (p Page) Apply(query *gorm.DB, column string) *gorm.DB {
+	if p.After != 0 {
+		return query.Where(column+" > ?", p.After).Order(column + " ASC").Limit(p.Limit + 1)
+	}
+	if p.Before != 0 {
+		query = query.Where(column+" < ?", p.Before)
+	}
+	return query.Order(column + " DESC").Limit(p.Limit + 1)
+}
+
+// paginate trims the rows fetched with Apply to the page, puts them newest first and
+// works out the cursors for the neighbouring pages.
+func paginate[T any](p Page, kind string, rows []T, id func(T) uint) ([]T, Cursors) {
+	var cursors Cursors
+	hasMore := len(rows) > p.Limit
+	if hasMore {
+		rows = rows[:p.Limit]
+	}
+
+	if p.After != 0 {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	if len(rows) == 0 {
+		// Point back at the rows the cursor came from
+		if p.Before != 0 {
+			cursors.Prev = encodeCursor(kind, p.Before-1)
+		}
+		if p.After != 0 {
+			cursors.Next = encodeCursor(kind, p.After+1)
+		}
+		return rows, cursors
+	}
+
+	newest, oldest := id(rows[0]), id(rows[len(rows)-1])
+	if hasMore || p.After != 0 {
+		cursors.Next = encodeCursor(kind, oldest)
+	}
+	if p.Before != 0 || (p.After != 0 && hasMore) {
+		cursors.Prev = encodeCursor(kind, newest)
+	}
+	return rows, cursors
+}
+
+// setPageHeaders advertises the neighbouring pages in a Link header and in
+// X-Next-Cursor/X-Prev-Cursor, which keeps list bodies in the shape the simulator expects.
+func setPageHeaders(w http.ResponseWriter, r *http.Request, cursors Cursors) {
+	var links []string
+	link := func(param, cursor, rel string) {
+		query := r.URL.Query()
+		query.Del("before")
+		query.Del("after")
+		query.Del("latest")
+		query.Set(param, cursor)
+		u := *r.URL
+		u.RawQuery = query.Encode()
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel))
+	}
+
+	if cursors.Next != "" {
+		w.Header().Set("X-Next-Cursor", cursors.Next)
+		link("before", cursors.Next, "next")
+	}
+	if cursors.Prev != "" {
+		w.Header().Set("X-Prev-Cursor", cursors.Prev)
+		link("after", cursors.Prev, "prev")
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestParsePage(t *testing.T) {
+	cases := []struct {
+		query string
+		want  Page
+		err   bool
+	}{
+		{"", Page{Limit: DEFAULT_NO}, false},
+		{"?no=20", Page{Limit: 20}, false},
+		{"?no=0", Page{Limit: DEFAULT_NO}, false},
+		{"?no=nope", Page{Limit: DEFAULT_NO}, false},
+		{"?no=" + strconv.Itoa(MAX_PAGE_SIZE+1), Page{Limit: MAX_PAGE_SIZE}, false},
+		{"?no=" + strconv.Itoa(int(^uint(0)>>1)), Page{Limit: MAX_PAGE_SIZE}, false},
+		{"?before=" + encodeCursor(CURSOR_MESSAGE, 7), Page{Before: 7, Limit: DEFAULT_NO}, false},
+		{"?after=" + encodeCursor(CURSOR_MESSAGE, 7), Page{After: 7, Limit: DEFAULT_NO}, false},
+		{"?before=" + encodeCursor(CURSOR_USER, 7), Page{}, true},
+		{"?before=garbage", Page{}, true},
+		{"?before=" + encodeCursor(CURSOR_MESSAGE, 7) + "&after=" + encodeCursor(CURSOR_MESSAGE, 3), Page{}, true},
+	}
+	for _, c := range cases {
+		page, err := parsePage(httptest.NewRequest("GET", "/msgs"+c.query, nil), CURSOR_MESSAGE, DEFAULT_NO)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", c.query, page)
+			}
+			continue
+		}
+		if err != nil || page != c.want {
+			t.Errorf("%s: got %+v, %v, want %+v", c.query, page, err, c.want)
+		}
+	}
+}
+
+func TestPaginateMessages(t *testing.T) {
+	server := newTestServer(t)
+	register(t, server, "alice")
+	for i := 1; i <= 5; i++ {
+		expectStatus(t, simulate(t, server, "POST", "/msgs/alice", fmt.Sprintf(`{"content":"message %d"}`, i)), http.StatusNoContent)
+	}
+
+	list := func(query string) ([]string, *http.Response) {
+		t.Helper()
+		resp := simulate(t, server, "GET", "/msgs?no=2"+query, "")
+		expectStatus(t, resp, http.StatusOK)
+		var messages []MessageResponse
+		decode(t, resp, &messages)
+		contents := make([]string, 0, len(messages))
+		for _, m := range messages {
+			contents = append(contents, m.Content)
+		}
+		return contents, resp
+	}
+
+	first, resp := list("")
+	if fmt.Sprint(first) != "[message 5 message 4]" || resp.Header.Get("X-Prev-Cursor") != "" {
+		t.Fatalf("unexpected first page %v, headers %v", first, resp.Header)
+	}
+	second, resp := list("&before=" + resp.Header.Get("X-Next-Cursor"))
+	if fmt.Sprint(second) != "[message 3 message 2]" || resp.Header.Get("Link") == "" {
+		t.Fatalf("unexpected second page %v, headers %v", second, resp.Header)
+	}
+	next := resp.Header.Get("X-Next-Cursor")
+	back, _ := list("&after=" + resp.Header.Get("X-Prev-Cursor"))
+	if fmt.Sprint(back) != fmt.Sprint(first) {
+		t.Fatalf("paging back: got %v, want %v", back, first)
+	}
+	last, resp := list("&before=" + next)
+	if fmt.Sprint(last) != "[message 1]" || resp.Header.Get("X-Next-Cursor") != "" {
+		t.Fatalf("unexpected last page %v, headers %v", last, resp.Header)
+	}
+
+	expectStatus(t, simulate(t, server, "GET", "/msgs?before="+encodeCursor(CURSOR_USER, 3), ""), http.StatusBadRequest)
+}
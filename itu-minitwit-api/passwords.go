@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DUMMY_PASSWORD is the placeholder stored by createDummyUser. Accounts holding it
+// were never registered by a real user and must never be able to log in.
+const DUMMY_PASSWORD = "dummy"
+
+var ErrDummyPassword = errors.New("account has no real password")
+
+// PasswordHasher hashes passwords on register and checks them on login.
+type PasswordHasher interface {
+	// Hash returns an encoded hash of the password, including salt and parameters.
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches an encoded hash produced by this hasher.
+	Verify(encoded, password string) (bool, error)
+	// Recognizes reports whether the encoded value was produced by this hasher.
+	Recognizes(encoded string) bool
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h bcryptHasher) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h bcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+type argon2idHasher struct {
+	time    uint32
+	memory  uint32 // in KiB
+	threads uint8
+	keyLen  uint32
+}
+
+// Hash encodes in the same format as the argon2 reference implementation:
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, h.keyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h argon2idHasher) Verify(encoded, password string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, err
+	}
+	if version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, err
+	}
+
+	// Use the parameters stored with the hash so that changing the configured cost
+	// does not lock out existing users.
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+// newPasswordHasher builds the hasher selected by the passwords configuration, which
+// Config.Validate has checked.
+func newPasswordHasher(cfg PasswordConfig) (PasswordHasher, error) {
+	switch cfg.Hasher {
+	case "bcrypt":
+		return bcryptHasher{cost: cfg.BcryptCost}, nil
+	case "argon2id":
+		return argon2idHasher{time: uint32(cfg.Argon2Time), memory: uint32(cfg.Argon2Memory), threads: uint8(cfg.Argon2Threads), keyLen: 32}, nil
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASHER %q", cfg.Hasher)
+	}
+}
+
+// knownHashers lists every scheme a stored hash may be in, so logins keep working
+// after PASSWORD_HASHER is switched.
+var knownHashers = []PasswordHasher{bcryptHasher{}, argon2idHasher{}}
+
+// checkPassword compares a login attempt against the stored pw_hash. Rows written before
+// hashing was introduced still hold the plaintext password; those, and hashes made by a
+// different scheme than the configured one, are reported with needsUpgrade so the caller
+// can store a fresh hash.
+func checkPassword(hasher PasswordHasher, stored, password string) (ok bool, needsUpgrade bool, err error) {
+	if stored == DUMMY_PASSWORD {
+		return false, false, ErrDummyPassword
+	}
+
+	for _, h := range knownHashers {
+		if h.Recognizes(stored) {
+			ok, err := h.Verify(stored, password)
+			if err != nil || !ok {
+				return false, false, err
+			}
+			return true, !hasher.Recognizes(stored), nil
+		}
+	}
+
+	// Legacy plaintext row
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1 {
+		return true, true, nil
+	}
+	return false, false, nil
+}
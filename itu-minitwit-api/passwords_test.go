@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	bcrypt := bcryptHasher{cost: 4}
+	argon2 := argon2idHasher{time: 1, memory: 64, threads: 1, keyLen: 32}
+	bcryptHash, err := bcrypt.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2Hash, err := argon2.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name             string
+		stored, password string
+		ok, needsUpgrade bool
+	}{
+		{"bcrypt", bcryptHash, "secret", true, false},
+		{"bcrypt wrong password", bcryptHash, "guess", false, false},
+		{"other scheme", argon2Hash, "secret", true, true},
+		{"legacy plaintext", "secret", "secret", true, true},
+		{"legacy plaintext wrong password", "secret", "guess", false, false},
+	}
+	for _, c := range cases {
+		ok, needsUpgrade, err := checkPassword(bcrypt, c.stored, c.password)
+		if err != nil || ok != c.ok || needsUpgrade != c.needsUpgrade {
+			t.Errorf("%s: got ok=%v needsUpgrade=%v err=%v", c.name, ok, needsUpgrade, err)
+		}
+	}
+
+	if _, _, err := checkPassword(bcrypt, DUMMY_PASSWORD, DUMMY_PASSWORD); !errors.Is(err, ErrDummyPassword) {
+		t.Errorf("dummy password: got err=%v", err)
+	}
+}
+
+func TestLoginUpgradesLegacyPassword(t *testing.T) {
+	api := newTestAPI(t)
+	server := newTestServerFor(t, api)
+	legacy := User{Username: "alice", Email: "alice@example.com", PWHash: "secret"}
+	if err := api.store.CreateUser(&legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(password string) *http.Response {
+		req, err := http.NewRequest("POST", server.URL+"/login", strings.NewReader(`{"username":"alice","password":"`+password+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(SERVICE_TOKEN_HEADER, "service")
+		return send(t, req)
+	}
+	expectStatus(t, login("guess"), http.StatusUnauthorized)
+	expectStatus(t, login("secret"), http.StatusOK)
+
+	user, err := api.store.GetUserByUsername("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !api.hasher.Recognizes(user.PWHash) {
+		t.Fatalf("password was not upgraded, stored %q", user.PWHash)
+	}
+	expectStatus(t, login("secret"), http.StatusOK)
+}
+
+func TestRegisterRejectsLongPassword(t *testing.T) {
+	server := newTestServer(t)
+	body := `{"username":"alice","email":"alice@example.com","pwd":"` + strings.Repeat("x", 73) + `"}`
+	resp := simulate(t, server, "POST", "/register", body)
+	expectStatus(t, resp, http.StatusBadRequest)
+	var response APIError
+	decode(t, resp, &response)
+	if response.Code != ERR_VALIDATION || response.Fields["pwd"] == "" {
+		t.Fatalf("unexpected error response %+v", response)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LEGACY_PUB_DATE_LAYOUT is how pub_date used to be stored, formatted in the server's
+// time zone.
+const LEGACY_PUB_DATE_LAYOUT = "Jan 2, 2006 at 3:04PM"
+
+// parseLegacyPubDate understands every format pub_date has been stored in: the
+// formatted string, unix seconds from the original schema.sql, and RFC 3339.
+func parseLegacyPubDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	if t, err := time.ParseInLocation(LEGACY_PUB_DATE_LAYOUT, value, time.Local); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognised pub_date %q", value)
+}
+
+func timestampType(tx *gorm.DB) string {
+	if tx.Dialector.Name() == "postgres" {
+		return "timestamptz"
+	}
+	return "datetime"
+}
+
+// PUB_DATE_BATCH is how many distinct pub_date values or messages a migration step reads
+// at once.
+const PUB_DATE_BATCH = 1000
+
+// unixPubDateUpdate converts the unix seconds of the original schema.sql in one statement.
+// The other formats depend on the server's time zone and are parsed by parseLegacyPubDate.
+func unixPubDateUpdate(tx *gorm.DB) string {
+	if tx.Dialector.Name() == "postgres" {
+		return `UPDATE messages SET pub_date_ts = to_timestamp(CAST(pub_date AS bigint))
+		WHERE pub_date ~ '^[0-9]{1,12}$'`
+	}
+	return `UPDATE messages SET pub_date_ts = strftime('%Y-%m-%d %H:%M:%S+00:00', CAST(pub_date AS INTEGER), 'unixepoch')
+	WHERE length(pub_date) BETWEEN 1 AND 12 AND pub_date NOT GLOB '*[^0-9]*'`
+}
+
+// pubDateToTimestamp converts the text pub_date column into a timestamp column, parsing
+// the existing values.
+func pubDateToTimestamp(tx *gorm.DB) error {
+	if err := tx.Exec("ALTER TABLE messages ADD COLUMN pub_date_ts " + timestampType(tx)).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec(unixPubDateUpdate(tx)).Error; err != nil {
+		return err
+	}
+
+	// The formatted dates only have minutes, so messages share values and each distinct
+	// value is updated at once
+	var failed int64
+	for {
+		var values []string
+		err := tx.Table("messages").Distinct("pub_date").Where("pub_date_ts IS NULL").
+			Order("pub_date").Limit(PUB_DATE_BATCH).Pluck("pub_date", &values).Error
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			break
+		}
+		for _, value := range values {
+			pubDate, parseErr := parseLegacyPubDate(value)
+			if parseErr != nil {
+				// Keep the message, but it sorts as the oldest one
+				pubDate = time.Unix(0, 0).UTC()
+			}
+			result := tx.Table("messages").Where("pub_date = ? AND pub_date_ts IS NULL", value).Update("pub_date_ts", pubDate)
+			if result.Error != nil {
+				return result.Error
+			}
+			if parseErr != nil {
+				failed += result.RowsAffected
+			}
+		}
+	}
+	if failed > 0 {
+		logger.WithField("messages", failed).Warn("Could not parse pub_date, set to the unix epoch")
+	}
+
+	if err := tx.Migrator().DropColumn(&messageV1{}, "pub_date"); err != nil {
+		return err
+	}
+	return tx.Migrator().RenameColumn(&messageV1{}, "pub_date_ts", "pub_date")
+}
+
+// pubDateToText reverts pubDateToTimestamp, formatting the timestamps the way they used to
+// be stored.
+func pubDateToText(tx *gorm.DB) error {
+	if err := tx.Exec("ALTER TABLE messages ADD COLUMN pub_date_text text").Error; err != nil {
+		return err
+	}
+
+	type row struct {
+		MessageID uint
+		PubDate   time.Time
+	}
+	var last uint
+	for {
+		var rows []row
+		err := tx.Table("messages").Select("message_id, pub_date").Where("message_id > ?", last).
+			Order("message_id").Limit(PUB_DATE_BATCH).Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		// Messages of the same minute format the same and are updated together
+		batch := map[string][]uint{}
+		for _, r := range rows {
+			formatted := r.PubDate.In(time.Local).Format(LEGACY_PUB_DATE_LAYOUT)
+			batch[formatted] = append(batch[formatted], r.MessageID)
+		}
+		for formatted, ids := range batch {
+			if err := tx.Table("messages").Where("message_id IN ?", ids).Update("pub_date_text", formatted).Error; err != nil {
+				return err
+			}
+		}
+		last = rows[len(rows)-1].MessageID
+	}
+
+	if err := tx.Migrator().DropColumn(&messageV1{}, "pub_date"); err != nil {
+		return err
+	}
+	return tx.Migrator().RenameColumn(&messageV1{}, "pub_date_text", "pub_date")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseLegacyPubDate(t *testing.T) {
+	local := time.Date(2024, 3, 5, 14, 7, 0, 0, time.Local)
+	cases := []struct {
+		value string
+		want  time.Time
+		err   bool
+	}{
+		{strconv.FormatInt(local.Unix(), 10), local, false},
+		{" " + strconv.FormatInt(local.Unix(), 10) + "\n", local, false},
+		{local.Format(LEGACY_PUB_DATE_LAYOUT), local, false},
+		{local.Format(time.RFC3339), local, false},
+		{"2024-03-05T14:07:00Z", time.Date(2024, 3, 5, 14, 7, 0, 0, time.UTC), false},
+		{"yesterday", time.Time{}, true},
+		{"", time.Time{}, true},
+	}
+	for _, c := range cases {
+		got, err := parseLegacyPubDate(c.value)
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %s", c.value, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(c.want) {
+			t.Errorf("%q: got %s, %v, want %s", c.value, got, err, c.want)
+		}
+	}
+}
+
+// TestMigratePubDates converts more messages than fit in one batch, in every format
+// pub_date has been stored in, and back.
+func TestMigratePubDates(t *testing.T) {
+	db := newBaselineDB(t)
+	author := userV1{Username: "alice", Email: "alice@example.com", PWHash: "x"}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 3, 5, 14, 7, 0, 0, time.Local)
+	var messages []messageV1
+	var want []time.Time
+	add := func(pubDate string, at time.Time) {
+		messages = append(messages, messageV1{AuthorID: author.UserID, Text: "hi", PubDate: pubDate})
+		want = append(want, at)
+	}
+	for i := 0; i < 3*PUB_DATE_BATCH/2; i++ {
+		at := start.Add(time.Duration(i/3) * time.Minute)
+		add(at.Format(LEGACY_PUB_DATE_LAYOUT), at)
+	}
+	add(strconv.FormatInt(start.Unix()+30, 10), start.Add(30*time.Second))
+	add(start.Format(time.RFC3339), start)
+	add("yesterday", time.Unix(0, 0))
+	if err := db.CreateInBatches(messages, 500).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	migrator := newTestMigrator(t, db)
+	expectTimestamps := func() {
+		t.Helper()
+		var migrated []messageV2
+		if err := db.Order("message_id").Find(&migrated).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(migrated) != len(want) {
+			t.Fatalf("%d messages after the migration, want %d", len(migrated), len(want))
+		}
+		for i, m := range migrated {
+			if !m.PubDate.Equal(want[i]) {
+				t.Fatalf("message %d: pub_date %s, want %s", m.MessageID, m.PubDate, want[i])
+			}
+		}
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	expectTimestamps()
+
+	// Back to the text column of migration 2
+	if _, err := migrator.Down(len(schemaMigrations(defaultConfig().Database)) - 2); err != nil {
+		t.Fatal(err)
+	}
+	var reverted []messageV1
+	if err := db.Order("message_id").Find(&reverted).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range reverted {
+		if formatted := want[i].In(time.Local).Format(LEGACY_PUB_DATE_LAYOUT); m.PubDate != formatted {
+			t.Fatalf("message %d: pub_date %q after reverting, want %q", m.MessageID, m.PubDate, formatted)
+		}
+	}
+
+	// The reverted text loses the seconds of the unix timestamp
+	want[len(want)-3] = start
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	expectTimestamps()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"devoops/contract"
+)
+
+// MAX_REQUEST_BODY caps JSON request bodies, messages and registrations are far smaller.
+const MAX_REQUEST_BODY = 64 << 10
+
+// ValidationError is returned by the Validate methods the contract defines on request bodies.
+type ValidationError = contract.ValidationError
+
+var errTrailingData = errors.New("request body must contain a single JSON object")
+
+// validator is implemented by request bodies that check their own fields.
+type validator interface {
+	Validate() error
+}
+
+// decodeJSON reads a single JSON object into req. Unknown fields are rejected, so typos in
+// field names are reported instead of silently ignored. An empty body decodes as {}.
+func decodeJSON(w http.ResponseWriter, r *http.Request, req interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_REQUEST_BODY))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(req)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	} else if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errTrailingData
+	}
+	return err
+}
+
+// requestError turns a decoding or validation error into the response for it, nil for
+// any other error.
+func requestError(err error) *APIError {
+	var validation *ValidationError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var tooLarge *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &validation):
+		apiErr := newAPIError(http.StatusBadRequest, ERR_VALIDATION, validation.Message)
+		apiErr.Fields = validation.Fields
+		return apiErr
+	case errors.As(err, &tooLarge):
+		return newAPIError(http.StatusRequestEntityTooLarge, ERR_BODY_TOO_LARGE,
+			fmt.Sprintf("Request body must not be larger than %d bytes", tooLarge.Limit))
+	case errors.As(err, &syntaxErr):
+		return newAPIError(http.StatusBadRequest, ERR_MALFORMED_BODY, fmt.Sprintf("Malformed JSON at position %d", syntaxErr.Offset))
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, errTrailingData):
+		return newAPIError(http.StatusBadRequest, ERR_MALFORMED_BODY, "Malformed JSON")
+	case errors.As(err, &typeErr):
+		message := fmt.Sprintf("%s must be a %s", typeErr.Field, typeErr.Type)
+		apiErr := newAPIError(http.StatusBadRequest, ERR_VALIDATION, message)
+		apiErr.Fields = map[string]string{typeErr.Field: message}
+		return apiErr
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		apiErr := newAPIError(http.StatusBadRequest, ERR_VALIDATION, fmt.Sprintf("Unknown field %s", field))
+		apiErr.Fields = map[string]string{field: "unknown field"}
+		return apiErr
+	default:
+		return nil
+	}
+}
+
+// decodeRequest decodes and validates the JSON body of r into req. On failure it writes
+// the error response and returns false.
+func decodeRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	err := decodeJSON(w, r, req)
+	if v, ok := req.(validator); ok && err == nil {
+		err = v.Validate()
+	}
+	if err != nil {
+		requestLogger(r).WithError(err).WithField("path", r.URL.Path).Warn("Rejected request body")
+		writeError(w, r, err)
+		return false
+	}
+	return true
+}
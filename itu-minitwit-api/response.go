@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// responseWriter remembers the status sent, so the response layer can tell whether headers
+// are already out, and drops bodies that the status does not allow. It counts the bytes
+// written for the access log.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		logger.WithFields(logrus.Fields{"status": w.status, "ignored": status}).Warn("Response status sent twice")
+		return
+	}
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !bodyAllowed(w.status) {
+		return len(data), nil
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += n
+	return n, err
+}
+
+func (w *responseWriter) Status() int { return w.status }
+
+// sentStatus is the status the client received, 200 if the handler never set one.
+func (w *responseWriter) sentStatus() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// trackResponse wraps w, unless an outer middleware already did.
+func trackResponse(w http.ResponseWriter) *responseWriter {
+	if tracked, ok := w.(*responseWriter); ok {
+		return tracked
+	}
+	return &responseWriter{ResponseWriter: w}
+}
+
+// SLOW_REQUEST is the duration from which requests are logged as warnings.
+const SLOW_REQUEST = 2 * time.Second
+
+// AccessLogMiddleware logs one line per request once the response is written, with the
+// route template so requests to the same endpoint can be grouped.
+func AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		writer := trackResponse(w)
+		next.ServeHTTP(writer, r)
+
+		status := writer.sentStatus()
+		duration := time.Since(start)
+		entry := logger.WithFields(logrus.Fields{
+			"request_id":  requestID(r),
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"route":       routeTemplate(r),
+			"status":      status,
+			"bytes":       writer.bytes,
+			"duration_ms": float64(duration.Microseconds()) / 1000,
+			"remote_ip":   r.RemoteAddr,
+		})
+		switch {
+		case status >= 500:
+			entry.Error("Request failed")
+		case duration > SLOW_REQUEST:
+			entry.Warn("Slow request")
+		default:
+			entry.Info("Request completed")
+		}
+	})
+}
+
+// headerWritten reports whether the status line of the response has been sent.
+func headerWritten(w http.ResponseWriter) bool {
+	tracked, ok := w.(interface{ Status() int })
+	return ok && tracked.Status() != 0
+}
+
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// CheckEncodeResponse is the one place responses are written. The body is encoded into a
+// buffer first, so an encoding failure can still be answered with a 500 instead of a
+// truncated body. 204 and 304 responses are sent without one.
+func CheckEncodeResponse(w http.ResponseWriter, response interface{}, statusCode int) {
+	if headerWritten(w) {
+		logger.WithField("status", statusCode).Error("Response status was already sent")
+		return
+	}
+	if !bodyAllowed(statusCode) {
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(response); err != nil {
+		logger.WithError(err).Error("Error encoding response")
+		statusCode = http.StatusInternalServerError
+		apiErr := newAPIError(statusCode, ERR_INTERNAL, "Failed to encode response")
+		apiErr.RequestID = w.Header().Get(REQUEST_ID_HEADER)
+		body.Reset()
+		_ = json.NewEncoder(&body).Encode(apiErr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(body.Bytes()); err != nil {
+		logger.WithError(err).Warn("Failed to write response")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Ways of matching messages against a search query, picked by detectSearchBackend.
+const (
+	SEARCH_FTS5     = "fts5"     // SQLite messages_fts virtual table
+	SEARCH_TSVECTOR = "tsvector" // Postgres messages.search_vector column
+	SEARCH_LIKE     = "like"     // no index, e.g. SQLite built without FTS5
+)
+
+// MAX_SEARCH_TERMS keeps queries from growing into very expensive statements.
+const MAX_SEARCH_TERMS = 10
+
+// sqliteHasFTS5 reports whether the SQLite driver was built with the sqlite_fts5 tag.
+func sqliteHasFTS5(tx *gorm.DB) bool {
+	var enabled int
+	err := tx.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled).Error
+	return err == nil && enabled == 1
+}
+
+// createSearchIndex indexes message text. On SQLite the FTS5 table is an external content
+// table kept up to date by triggers, so a migration that recreates messages must also
+// recreate the index.
+func createSearchIndex(tx *gorm.DB) error {
+	if tx.Dialector.Name() == "postgres" {
+		err := tx.Exec(`ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector
+			GENERATED ALWAYS AS (to_tsvector('english', text)) STORED`).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec("CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING GIN (search_vector)").Error
+	}
+
+	if !sqliteHasFTS5(tx) {
+		logger.Warn("SQLite was built without FTS5, search falls back to LIKE. Build with -tags sqlite_fts5 and rerun this migration to index messages")
+		return nil
+	}
+	statements := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+			text, content='messages', content_rowid='message_id', tokenize='porter unicode61')`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts(rowid, text) VALUES (new.message_id, new.text);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts(messages_fts, rowid, text) VALUES ('delete', old.message_id, old.text);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF text ON messages BEGIN
+			INSERT INTO messages_fts(messages_fts, rowid, text) VALUES ('delete', old.message_id, old.text);
+			INSERT INTO messages_fts(rowid, text) VALUES (new.message_id, new.text);
+		END`,
+		`INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')`,
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dropSearchIndex(tx *gorm.DB) error {
+	if tx.Dialector.Name() == "postgres" {
+		if err := tx.Exec("DROP INDEX IF EXISTS idx_messages_search").Error; err != nil {
+			return err
+		}
+		return tx.Exec("ALTER TABLE messages DROP COLUMN IF EXISTS search_vector").Error
+	}
+	for _, statement := range []string{
+		"DROP TRIGGER IF EXISTS messages_fts_insert",
+		"DROP TRIGGER IF EXISTS messages_fts_delete",
+		"DROP TRIGGER IF EXISTS messages_fts_update",
+		"DROP TABLE IF EXISTS messages_fts",
+	} {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// detectSearchBackend checks which index createSearchIndex managed to build.
+func detectSearchBackend(db *gorm.DB) string {
+	if db.Dialector.Name() == "postgres" {
+		if db.Migrator().HasColumn(&Message{}, "search_vector") {
+			return SEARCH_TSVECTOR
+		}
+		return SEARCH_LIKE
+	}
+	if db.Migrator().HasTable("messages_fts") && sqliteHasFTS5(db) {
+		return SEARCH_FTS5
+	}
+	return SEARCH_LIKE
+}
+
+// searchTerms splits a query into at most MAX_SEARCH_TERMS words.
+func searchTerms(q string) []string {
+	terms := strings.Fields(q)
+	if len(terms) > MAX_SEARCH_TERMS {
+		terms = terms[:MAX_SEARCH_TERMS]
+	}
+	return terms
+}
+
+// fts5Query quotes every term, so input is never parsed as FTS5 query syntax. Terms are
+// matched as prefixes and all of them must match.
+func fts5Query(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// matchMessages restricts a query on messages to those matching all search terms.
+func matchMessages(query *gorm.DB, backend string, terms []string) *gorm.DB {
+	switch backend {
+	case SEARCH_FTS5:
+		return query.Where("messages.message_id IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH ?)", fts5Query(terms))
+	case SEARCH_TSVECTOR:
+		return query.Where("messages.search_vector @@ websearch_to_tsquery('english', ?)", strings.Join(terms, " "))
+	default:
+		for _, term := range terms {
+			query = query.Where(`LOWER(messages.text) LIKE ? ESCAPE '\'`, "%"+likeEscaper.Replace(strings.ToLower(term))+"%")
+		}
+		return query
+	}
+}
+
+// SearchMessagesHandler lists the non-flagged messages matching ?q=, newest first and
+// paginated like /msgs.
+func (api *API) SearchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	terms := searchTerms(r.URL.Query().Get("q"))
+	if len(terms) == 0 {
+		api.metrics.BadRequests.WithLabelValues("search").Inc()
+		writeError(w, r, newAPIError(http.StatusBadRequest, ERR_BAD_REQUEST, "Missing search query"))
+		return
+	}
+
+	page, err := parsePage(r, CURSOR_MESSAGE, api.pageSize)
+	if err != nil {
+		api.metrics.BadRequests.WithLabelValues("search").Inc()
+		writeError(w, r, newAPIError(http.StatusBadRequest, ERR_BAD_REQUEST, err.Error()))
+		return
+	}
+
+	messages, err := api.store.ListMessages(MessageFilter{Terms: terms}, page)
+	if err != nil {
+		logger.WithError(err).Error("Failed to search messages")
+		api.metrics.BadRequests.WithLabelValues("search").Inc()
+		writeError(w, r, newAPIError(http.StatusInternalServerError, ERR_INTERNAL, "Query execution failed"))
+		return
+	}
+
+	messages, cursors := paginate(page, CURSOR_MESSAGE, messages, func(m APIMessage) uint { return m.MessageID })
+	logger.WithField("message_count", len(messages)).Debug("Search completed")
+	api.metrics.SuccessfulRequests.WithLabelValues("search").Inc()
+
+	filteredMsgs := toMessageResponses(messages)
+
+	setPageHeaders(w, r, cursors)
+	CheckEncodeResponse(w, filteredMsgs, http.StatusOK)
+}
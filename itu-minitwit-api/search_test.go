@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSearchQueries(t *testing.T) {
+	terms := searchTerms("  hello   " + strings.Repeat("w ", MAX_SEARCH_TERMS+5))
+	if len(terms) != MAX_SEARCH_TERMS || terms[0] != "hello" {
+		t.Fatalf("unexpected terms %q", terms)
+	}
+	if got := fts5Query([]string{"say", `"hi"`, "OR"}); got != `"say"* """hi"""* "OR"*` {
+		t.Fatalf("unexpected FTS5 query %s", got)
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	server := newTestServer(t)
+	register(t, server, "alice")
+	for _, content := range []string{"Hello world", "goodbye world", "100% sure"} {
+		expectStatus(t, simulate(t, server, "POST", "/msgs/alice", fmt.Sprintf(`{"content":%q}`, content)), http.StatusNoContent)
+	}
+
+	search := func(q string) []string {
+		t.Helper()
+		resp := fromFrontend(t, server, "/search?q="+url.QueryEscape(q))
+		expectStatus(t, resp, http.StatusOK)
+		var messages []MessageResponse
+		decode(t, resp, &messages)
+		contents := make([]string, 0, len(messages))
+		for _, m := range messages {
+			contents = append(contents, m.Content)
+		}
+		return contents
+	}
+
+	if got := search("world"); fmt.Sprint(got) != "[goodbye world Hello world]" {
+		t.Fatalf("world: got %q", got)
+	}
+	if got := search("hello WORLD"); fmt.Sprint(got) != "[Hello world]" {
+		t.Fatalf("hello WORLD: got %q", got)
+	}
+	if got := search("nothing"); len(got) != 0 {
+		t.Fatalf("nothing: got %q", got)
+	}
+	expectStatus(t, fromFrontend(t, server, "/search?q=+"), http.StatusBadRequest)
+}
+
+func TestSearchGormStore(t *testing.T) {
+	store := newTestGormStore(t)
+	alice := User{Username: "alice", Email: "alice@example.com", PWHash: "x"}
+	if err := store.CreateUser(&alice); err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"Hello world", "goodbye world", "100% sure", "50 percent"} {
+		if err := store.CreateMessage(&Message{AuthorID: alice.UserID, Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		terms []string
+		want  string
+	}{
+		{[]string{"world"}, "[goodbye world Hello world]"},
+		{[]string{"hello", "world"}, "[Hello world]"},
+		{[]string{"100%"}, "[100% sure]"},
+		{[]string{"%"}, "[100% sure]"},
+		{[]string{"nothing"}, "[]"},
+	}
+	for _, c := range cases {
+		if store.search == SEARCH_FTS5 && strings.Contains(c.terms[0], "%") {
+			continue // FTS5 tokenizes punctuation away
+		}
+		messages, err := store.ListMessages(MessageFilter{Terms: c.terms}, Page{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s %q: %v", store.search, c.terms, err)
+		}
+		contents := make([]string, 0, len(messages))
+		for _, m := range messages {
+			contents = append(contents, m.Content)
+		}
+		if got := fmt.Sprint(contents); got != c.want {
+			t.Errorf("%s %q: got %s, want %s", store.search, c.terms, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// STATS_REFRESH is how often the business metrics are recounted. Scrapes in between are
+// answered from the last count.
+const STATS_REFRESH = time.Minute
+
+// statsCollector exports row counts and the simulator's latest id. Growth is the change
+// since the previous refresh, so it reads as "per STATS_REFRESH".
+type statsCollector struct {
+	store interface {
+		StatsStore
+		SimulatorStore
+	}
+
+	mu       sync.Mutex
+	current  Stats
+	previous Stats
+	latest   int
+	counted  bool
+
+	totals, growth map[string]*prometheus.Desc
+	latestDesc     *prometheus.Desc
+}
+
+var statsTables = []string{"users", "messages", "flagged_messages", "follows"}
+
+func newStatsCollector(store Store) *statsCollector {
+	c := &statsCollector{
+		store:  store,
+		totals: map[string]*prometheus.Desc{},
+		growth: map[string]*prometheus.Desc{},
+		latestDesc: prometheus.NewDesc("minitwit_simulator_latest",
+			"Latest action id sent by the simulator", nil, nil),
+	}
+	for _, name := range statsTables {
+		c.totals[name] = prometheus.NewDesc("minitwit_"+name,
+			"Number of "+name+" in the database", nil, nil)
+		c.growth[name] = prometheus.NewDesc("minitwit_"+name+"_growth",
+			"Change in the number of "+name+" since the previous count", nil, nil)
+	}
+	return c
+}
+
+func (s Stats) byName() map[string]int64 {
+	return map[string]int64{
+		"users":            s.Users,
+		"messages":         s.Messages,
+		"flagged_messages": s.FlaggedMessages,
+		"follows":          s.Follows,
+	}
+}
+
+// refresh counts the rows again. On failure the last count is kept.
+func (c *statsCollector) refresh() {
+	stats, err := c.store.Stats()
+	if err != nil {
+		logger.WithError(err).Error("Failed to count rows for metrics")
+		return
+	}
+	latest, err := c.store.LoadLatest()
+	if err != nil {
+		logger.WithError(err).Error("Failed to load latest for metrics")
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.previous = c.current
+	if !c.counted {
+		c.previous = stats
+	}
+	c.current, c.latest, c.counted = stats, latest, true
+}
+
+// RefreshEvery counts once right away and then every interval in the background.
+func (c *statsCollector) RefreshEvery(interval time.Duration) {
+	c.refresh()
+	go func() {
+		for range time.Tick(interval) {
+			c.refresh()
+		}
+	}()
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, name := range statsTables {
+		ch <- c.totals[name]
+		ch <- c.growth[name]
+	}
+	ch <- c.latestDesc
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.counted {
+		return
+	}
+	current, previous := c.current.byName(), c.previous.byName()
+	for _, name := range statsTables {
+		ch <- prometheus.MustNewConstMetric(c.totals[name], prometheus.GaugeValue, float64(current[name]))
+		ch <- prometheus.MustNewConstMetric(c.growth[name], prometheus.GaugeValue, float64(current[name]-previous[name]))
+	}
+	ch <- prometheus.MustNewConstMetric(c.latestDesc, prometheus.GaugeValue, float64(c.latest))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when the requested row does not exist.
+var ErrNotFound = errors.New("not found")
+
+// MessageFilter selects the non-flagged messages a listing returns. The zero value
+// selects every message.
+type MessageFilter struct {
+	AuthorID   uint     // only messages by this user
+	TimelineOf uint     // messages by this user and the users they follow
+	Terms      []string // messages containing all of these words
+}
+
+// Listings take a Page and return the rows Page.Apply would fetch: at most Limit+1 of
+// them, newest first unless paging with After. Pass the result through paginate.
+
+type UserStore interface {
+	GetUserByID(id uint) (User, error)
+	GetUserByUsername(username string) (User, error)
+	CreateUser(user *User) error
+	UpdatePasswordHash(userID uint, pwHash string) error
+}
+
+type MessageStore interface {
+	CreateMessage(message *Message) error
+	GetMessage(id uint) (APIMessage, error)
+	ListMessages(filter MessageFilter, page Page) ([]APIMessage, error)
+}
+
+type FollowerStore interface {
+	// Follow returns false if who already followed whom.
+	Follow(who, whom uint) (bool, error)
+	// Unfollow returns false if who did not follow whom.
+	Unfollow(who, whom uint) (bool, error)
+	IsFollowing(who, whom uint) (bool, error)
+	ListFollowing(who uint, page Page) ([]UserDetails, error)
+}
+
+type ModerationStore interface {
+	// SetFlag flags or unflags a message and records the moderation, ErrNotFound if the
+	// message does not exist.
+	SetFlag(messageID uint, action, moderator, reason string) error
+	ListFlagged(page Page) ([]FlaggedMessage, error)
+}
+
+// SimulatorStore keeps the simulator's latest action id and the commands it sent.
+type SimulatorStore interface {
+	// StoreLatest records the id unless a higher one is stored already.
+	StoreLatest(latest int) error
+	LoadLatest() (int, error)
+
+	// ClaimCommand records a command as being processed, false if its id is taken.
+	ClaimCommand(command ProcessedCommand) (bool, error)
+	GetCommand(latest int64) (ProcessedCommand, error)
+	CompleteCommand(latest int64, status int, contentType, body string) error
+	ReleaseCommand(latest int64) error
+	PruneCommands(before time.Time) (int64, error)
+}
+
+// Stats are the row counts exported as business metrics.
+type Stats struct {
+	Users           int64
+	Messages        int64
+	FlaggedMessages int64
+	Follows         int64
+}
+
+type StatsStore interface {
+	// Stats counts rows with aggregate queries only, it is called on a timer.
+	Stats() (Stats, error)
+}
+
+type HealthStore interface {
+	// Ping checks that the database answers.
+	Ping(ctx context.Context) error
+}
+
+// Store is everything the handlers need from the database.
+type Store interface {
+	UserStore
+	MessageStore
+	FollowerStore
+	ModerationStore
+	SimulatorStore
+	StatsStore
+	HealthStore
+}
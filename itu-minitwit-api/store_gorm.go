@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// gormStore is the Store backed by the SQLite or Postgres database from connectDB.
+type gormStore struct {
+	db     *gorm.DB
+	search string // one of the SEARCH_ backends
+}
+
+func newGormStore(db *gorm.DB) *gormStore {
+	search := detectSearchBackend(db)
+	logger.WithField("backend", search).Info("Message search backend selected")
+	return &gormStore{db: db, search: search}
+}
+
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *gormStore) GetUserByID(id uint) (User, error) {
+	var user User
+	err := s.db.Where("user_id = ?", id).Take(&user).Error
+	return user, notFound(err)
+}
+
+func (s *gormStore) GetUserByUsername(username string) (User, error) {
+	var user User
+	err := s.db.Where("username = ?", username).Take(&user).Error
+	return user, notFound(err)
+}
+
+func (s *gormStore) CreateUser(user *User) error {
+	return s.db.Create(user).Error
+}
+
+func (s *gormStore) UpdatePasswordHash(userID uint, pwHash string) error {
+	return s.db.Model(&User{}).Where("user_id = ?", userID).Update("pw_hash", pwHash).Error
+}
+
+func (s *gormStore) CreateMessage(message *Message) error {
+	return s.db.Create(message).Error
+}
+
+func (s *gormStore) messages() *gorm.DB {
+	return s.db.Table("messages").
+		Select("messages.message_id AS message_id, messages.author_id AS author_id, messages.text AS content, messages.pub_date AS pub_date, users.username AS user").
+		Joins("JOIN users ON messages.author_id = users.user_id").
+		Where("messages.flagged = 0")
+}
+
+func (s *gormStore) GetMessage(id uint) (APIMessage, error) {
+	var message APIMessage
+	err := s.messages().Where("messages.message_id = ?", id).Take(&message).Error
+	return message, notFound(err)
+}
+
+func (s *gormStore) ListMessages(filter MessageFilter, page Page) ([]APIMessage, error) {
+	query := s.messages()
+	if filter.AuthorID != 0 {
+		query = query.Where("messages.author_id = ?", filter.AuthorID)
+	}
+	if filter.TimelineOf != 0 {
+		query = query.Where("(messages.author_id = ? OR messages.author_id IN (SELECT whom_id FROM followers WHERE who_id = ?))",
+			filter.TimelineOf, filter.TimelineOf)
+	}
+	if len(filter.Terms) > 0 {
+		query = matchMessages(query, s.search, filter.Terms)
+	}
+
+	var messages []APIMessage
+	err := page.Apply(query, "messages.message_id").Find(&messages).Error
+	return messages, err
+}
+
+func (s *gormStore) Follow(who, whom uint) (bool, error) {
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&Follower{WhoID: who, WhomID: whom})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (s *gormStore) Unfollow(who, whom uint) (bool, error) {
+	result := s.db.Where("who_id = ? AND whom_id = ?", who, whom).Delete(&Follower{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (s *gormStore) IsFollowing(who, whom uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&Follower{}).Where("who_id = ? AND whom_id = ?", who, whom).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *gormStore) ListFollowing(who uint, page Page) ([]UserDetails, error) {
+	var users []UserDetails
+	err := page.Apply(s.db.
+		Table("users").
+		Select("users.user_id, users.username").
+		Joins("INNER JOIN followers ON followers.whom_id = users.user_id").
+		Where("followers.who_id = ?", who), "users.user_id").
+		Find(&users).
+		Error
+	return users, err
+}
+
+func (s *gormStore) SetFlag(messageID uint, action, moderator, reason string) error {
+	flagged := 0
+	if action == FLAG_ACTION_FLAG {
+		flagged = 1
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Message{}).Where("message_id = ?", messageID).Update("flagged", flagged)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Create(&MessageFlag{
+			MessageID: messageID,
+			Action:    action,
+			Moderator: moderator,
+			Reason:    reason,
+			CreatedAt: time.Now().UTC(),
+		}).Error
+	})
+}
+
+func (s *gormStore) ListFlagged(page Page) ([]FlaggedMessage, error) {
+	var messages []FlaggedMessage
+	err := page.Apply(s.db.Table("messages").
+		Select(`messages.message_id AS message_id, messages.text AS content, messages.pub_date AS pub_date,
+			users.username AS user, COALESCE(message_flags.moderator, '') AS flagged_by,
+			COALESCE(message_flags.reason, '') AS reason, message_flags.created_at AS flagged_at`).
+		Joins("JOIN users ON messages.author_id = users.user_id").
+		Joins(`LEFT JOIN message_flags ON message_flags.flag_id = (
+			SELECT MAX(flag_id) FROM message_flags latest
+			WHERE latest.message_id = messages.message_id AND latest.action = ?)`, FLAG_ACTION_FLAG).
+		Where("messages.flagged = 1"), "messages.message_id").
+		Find(&messages).Error
+	return messages, err
+}
+
+func (s *gormStore) Ping(ctx context.Context) error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Stats counts all tables in one round trip.
+func (s *gormStore) Stats() (Stats, error) {
+	var stats Stats
+	err := s.db.Raw(`SELECT
+		(SELECT COUNT(*) FROM users) AS users,
+		(SELECT COUNT(*) FROM messages) AS messages,
+		(SELECT COUNT(*) FROM messages WHERE flagged = 1) AS flagged_messages,
+		(SELECT COUNT(*) FROM followers) AS follows`).Scan(&stats).Error
+	return stats, err
+}
+
+// StoreLatest compares in the UPDATE itself, so concurrent requests and replicas cannot
+// move the id backwards.
+func (s *gormStore) StoreLatest(latest int) error {
+	return s.db.Model(&SimulatorLatest{}).
+		Where("id = ? AND latest < ?", SIMULATOR_LATEST_ROW, latest).
+		Update("latest", latest).Error
+}
+
+func (s *gormStore) LoadLatest() (int, error) {
+	var row SimulatorLatest
+	err := s.db.Where("id = ?", SIMULATOR_LATEST_ROW).Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return -1, nil
+	}
+	return row.Latest, err
+}
+
+func (s *gormStore) ClaimCommand(command ProcessedCommand) (bool, error) {
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&command)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (s *gormStore) GetCommand(latest int64) (ProcessedCommand, error) {
+	var command ProcessedCommand
+	err := s.db.Where("latest = ?", latest).Take(&command).Error
+	return command, notFound(err)
+}
+
+func (s *gormStore) CompleteCommand(latest int64, status int, contentType, body string) error {
+	return s.db.Model(&ProcessedCommand{}).Where("latest = ?", latest).Updates(map[string]interface{}{
+		"status":       status,
+		"content_type": contentType,
+		"body":         body,
+	}).Error
+}
+
+func (s *gormStore) ReleaseCommand(latest int64) error {
+	return s.db.Delete(&ProcessedCommand{}, "latest = ?", latest).Error
+}
+
+func (s *gormStore) PruneCommands(before time.Time) (int64, error) {
+	result := s.db.Where("created_at < ?", before).Delete(&ProcessedCommand{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore is a Store kept in memory, for tests and for running without a database.
+type memoryStore struct {
+	mu        sync.Mutex
+	users     []User
+	messages  []Message
+	followers map[[2]uint]bool
+	flags     []MessageFlag
+	latest    int
+	commands  map[int64]ProcessedCommand
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		followers: map[[2]uint]bool{},
+		latest:    -1,
+		commands:  map[int64]ProcessedCommand{},
+	}
+}
+
+// applyPage is Page.Apply for rows held in memory, sorted by ascending id.
+func applyPage[T any](p Page, rows []T, id func(T) uint) []T {
+	var window []T
+	if p.After != 0 {
+		for _, row := range rows {
+			if id(row) > p.After && len(window) <= p.Limit {
+				window = append(window, row)
+			}
+		}
+		return window
+	}
+	for i := len(rows) - 1; i >= 0 && len(window) <= p.Limit; i-- {
+		if p.Before == 0 || id(rows[i]) < p.Before {
+			window = append(window, rows[i])
+		}
+	}
+	return window
+}
+
+func (s *memoryStore) findUser(match func(User) bool) (User, error) {
+	for _, user := range s.users {
+		if match(user) {
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *memoryStore) GetUserByID(id uint) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findUser(func(u User) bool { return u.UserID == id })
+}
+
+func (s *memoryStore) GetUserByUsername(username string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findUser(func(u User) bool { return u.Username == username })
+}
+
+func (s *memoryStore) CreateUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.findUser(func(u User) bool { return u.Username == user.Username }); err == nil {
+		return errors.New("username already exists")
+	}
+	user.UserID = uint(len(s.users) + 1)
+	s.users = append(s.users, *user)
+	return nil
+}
+
+func (s *memoryStore) UpdatePasswordHash(userID uint, pwHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.users {
+		if s.users[i].UserID == userID {
+			s.users[i].PWHash = pwHash
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memoryStore) CreateMessage(message *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	message.MessageID = uint(len(s.messages) + 1)
+	s.messages = append(s.messages, *message)
+	return nil
+}
+
+func (s *memoryStore) toAPIMessage(message Message) APIMessage {
+	author, _ := s.findUser(func(u User) bool { return u.UserID == message.AuthorID })
+	return APIMessage{
+		MessageID: message.MessageID,
+		AuthorID:  message.AuthorID,
+		Content:   message.Text,
+		PubDate:   message.PubDate,
+		User:      author.Username,
+	}
+}
+
+func (s *memoryStore) GetMessage(id uint) (APIMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, message := range s.messages {
+		if message.MessageID == id && message.Flagged == 0 {
+			return s.toAPIMessage(message), nil
+		}
+	}
+	return APIMessage{}, ErrNotFound
+}
+
+func (s *memoryStore) matches(filter MessageFilter, message Message) bool {
+	if message.Flagged != 0 {
+		return false
+	}
+	if filter.AuthorID != 0 && message.AuthorID != filter.AuthorID {
+		return false
+	}
+	if filter.TimelineOf != 0 && message.AuthorID != filter.TimelineOf && !s.followers[[2]uint{filter.TimelineOf, message.AuthorID}] {
+		return false
+	}
+	for _, term := range filter.Terms {
+		if !strings.Contains(strings.ToLower(message.Text), strings.ToLower(term)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *memoryStore) ListMessages(filter MessageFilter, page Page) ([]APIMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matching []APIMessage
+	for _, message := range s.messages {
+		if s.matches(filter, message) {
+			matching = append(matching, s.toAPIMessage(message))
+		}
+	}
+	return applyPage(page, matching, func(m APIMessage) uint { return m.MessageID }), nil
+}
+
+func (s *memoryStore) Follow(who, whom uint) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]uint{who, whom}
+	if s.followers[key] {
+		return false, nil
+	}
+	s.followers[key] = true
+	return true, nil
+}
+
+func (s *memoryStore) Unfollow(who, whom uint) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]uint{who, whom}
+	if !s.followers[key] {
+		return false, nil
+	}
+	delete(s.followers, key)
+	return true, nil
+}
+
+func (s *memoryStore) IsFollowing(who, whom uint) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.followers[[2]uint{who, whom}], nil
+}
+
+func (s *memoryStore) ListFollowing(who uint, page Page) ([]UserDetails, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var following []UserDetails
+	for _, user := range s.users {
+		if s.followers[[2]uint{who, user.UserID}] {
+			following = append(following, UserDetails{UserID: user.UserID, Username: user.Username})
+		}
+	}
+	return applyPage(page, following, func(u UserDetails) uint { return u.UserID }), nil
+}
+
+func (s *memoryStore) SetFlag(messageID uint, action, moderator, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.messages {
+		if s.messages[i].MessageID != messageID {
+			continue
+		}
+		s.messages[i].Flagged = 0
+		if action == FLAG_ACTION_FLAG {
+			s.messages[i].Flagged = 1
+		}
+		s.flags = append(s.flags, MessageFlag{
+			FlagID:    uint(len(s.flags) + 1),
+			MessageID: messageID,
+			Action:    action,
+			Moderator: moderator,
+			Reason:    reason,
+			CreatedAt: time.Now().UTC(),
+		})
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (s *memoryStore) ListFlagged(page Page) ([]FlaggedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var flagged []FlaggedMessage
+	for _, message := range s.messages {
+		if message.Flagged == 0 {
+			continue
+		}
+		m := s.toAPIMessage(message)
+		row := FlaggedMessage{MessageID: m.MessageID, Content: m.Content, PubDate: m.PubDate, User: m.User}
+		for i := len(s.flags) - 1; i >= 0; i-- {
+			if flag := s.flags[i]; flag.MessageID == message.MessageID && flag.Action == FLAG_ACTION_FLAG {
+				row.FlaggedBy, row.Reason, row.FlaggedAt = flag.Moderator, flag.Reason, &flag.CreatedAt
+				break
+			}
+		}
+		flagged = append(flagged, row)
+	}
+	return applyPage(page, flagged, func(m FlaggedMessage) uint { return m.MessageID }), nil
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error { return nil }
+
+func (s *memoryStore) Stats() (Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := Stats{Users: int64(len(s.users)), Messages: int64(len(s.messages))}
+	for _, message := range s.messages {
+		if message.Flagged != 0 {
+			stats.FlaggedMessages++
+		}
+	}
+	for _, following := range s.followers {
+		if following {
+			stats.Follows++
+		}
+	}
+	return stats, nil
+}
+
+func (s *memoryStore) StoreLatest(latest int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if latest > s.latest {
+		s.latest = latest
+	}
+	return nil
+}
+
+func (s *memoryStore) LoadLatest() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest, nil
+}
+
+func (s *memoryStore) ClaimCommand(command ProcessedCommand) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.commands[command.Latest]; found {
+		return false, nil
+	}
+	s.commands[command.Latest] = command
+	return true, nil
+}
+
+func (s *memoryStore) GetCommand(latest int64) (ProcessedCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	command, found := s.commands[latest]
+	if !found {
+		return ProcessedCommand{}, ErrNotFound
+	}
+	return command, nil
+}
+
+func (s *memoryStore) CompleteCommand(latest int64, status int, contentType, body string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	command, found := s.commands[latest]
+	if !found {
+		return ErrNotFound
+	}
+	command.Status, command.ContentType, command.Body = status, contentType, body
+	s.commands[latest] = command
+	return nil
+}
+
+func (s *memoryStore) ReleaseCommand(latest int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.commands, latest)
+	return nil
+}
+
+func (s *memoryStore) PruneCommands(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pruned int64
+	for latest, command := range s.commands {
+		if command.CreatedAt.Before(before) {
+			delete(s.commands, latest)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+var _ Store = (*memoryStore)(nil)
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestTimelineShowsFollowedUsers pins the direction of the timeline query: a user sees
+// the messages of the users they follow, not of the users following them.
+func TestTimelineShowsFollowedUsers(t *testing.T) {
+	stores := map[string]Store{"memory": newMemoryStore(), "gorm": newTestGormStore(t)}
+	for name, store := range stores {
+		users := map[string]*User{}
+		for _, username := range []string{"alice", "bob", "carol"} {
+			user := &User{Username: username, Email: username + "@example.com", PWHash: "x"}
+			if err := store.CreateUser(user); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.CreateMessage(&Message{AuthorID: user.UserID, Text: "by " + username}); err != nil {
+				t.Fatal(err)
+			}
+			users[username] = user
+		}
+		// alice follows bob, carol follows alice
+		if _, err := store.Follow(users["alice"].UserID, users["bob"].UserID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Follow(users["carol"].UserID, users["alice"].UserID); err != nil {
+			t.Fatal(err)
+		}
+
+		messages, err := store.ListMessages(MessageFilter{TimelineOf: users["alice"].UserID}, Page{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		contents := make([]string, 0, len(messages))
+		for _, m := range messages {
+			contents = append(contents, m.Content)
+		}
+		if got := fmt.Sprint(contents); got != "[by bob by alice]" {
+			t.Errorf("%s: alice's timeline is %s, want her own and bob's messages", name, got)
+		}
+	}
+}
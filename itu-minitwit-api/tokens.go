@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const DEFAULT_TOKEN_TTL = 16 * time.Hour // same as the frontend session
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// TokenClaims is the payload of the tokens handed out by PostLoginHandler.
+type TokenClaims struct {
+	Subject   string `json:"sub"` // username
+	UserID    uint   `json:"uid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// TokenIssuer signs and verifies HS256 JWTs.
+type TokenIssuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// newTokenIssuer signs with the configured secret. Without one a random secret is
+// generated, so tokens do not survive a restart.
+func newTokenIssuer(cfg TokenConfig) (*TokenIssuer, error) {
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		logger.Warn("TOKEN_SECRET is not set, using a random secret")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &TokenIssuer{secret: secret, ttl: cfg.TTL}, nil
+}
+
+func (t *TokenIssuer) sign(payload string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(jwtHeader + "." + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue returns a token for the user and the time it expires.
+func (t *TokenIssuer) Issue(user User) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(t.ttl)
+	claims, err := json.Marshal(TokenClaims{
+		Subject:   user.Username,
+		UserID:    user.UserID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return jwtHeader + "." + payload + "." + t.sign(payload), expires, nil
+}
+
+// Verify checks the signature and expiry of a token and returns its claims.
+func (t *TokenIssuer) Verify(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(t.sign(parts[1]))) {
+		return nil, ErrInvalidToken
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
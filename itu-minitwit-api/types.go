@@ -1,20 +1,64 @@
 package main
 
+import (
+	"time"
+
+	"devoops/contract"
+)
+
+// APIMessage is a message as the store reads it, it is sent as a contract.Message.
 type APIMessage struct {
-	Content string `json:"content"`
-	PubDate string `json:"pub_date"`
-	User    string `json:"username"`
+	MessageID uint
+	AuthorID  uint
+	Content   string
+	PubDate   time.Time
+	User      string
 }
 
-type UserDetails struct {
-	UserID   uint   `json:"user_id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
+// The request and response bodies are defined in the contract module shared with the
+// clients of the API.
+type (
+	MessageResponse = contract.Message
+	UserDetails     = contract.UserDetails
+	FollowsResponse = contract.FollowsResponse
+	RegisterRequest = contract.RegisterRequest
+	MessageRequest  = contract.MessageRequest
+	FollowRequest   = contract.FollowRequest
+	FollowResponse  = contract.FollowResponse
+	LoginRequest    = contract.LoginRequest
+	LoginResponse   = contract.LoginResponse
+	LatestResponse  = contract.LatestResponse
+	FlagRequest     = contract.FlagRequest
+	FlagResponse    = contract.FlagResponse
+	FlaggedMessage  = contract.FlaggedMessage
+	HealthCheck     = contract.HealthCheck
+	HealthResponse  = contract.HealthResponse
+)
+
+// Outcomes of a follow or unfollow request
+const (
+	FOLLOW_STATUS_FOLLOWED          = contract.FOLLOW_STATUS_FOLLOWED
+	FOLLOW_STATUS_ALREADY_FOLLOWING = contract.FOLLOW_STATUS_ALREADY_FOLLOWING
+	FOLLOW_STATUS_UNFOLLOWED        = contract.FOLLOW_STATUS_UNFOLLOWED
+	FOLLOW_STATUS_NOT_FOLLOWING     = contract.FOLLOW_STATUS_NOT_FOLLOWING
+)
+
+func toMessageResponse(msg APIMessage) MessageResponse {
+	return MessageResponse{
+		MessageID: msg.MessageID,
+		AuthorID:  msg.AuthorID,
+		Content:   msg.Content,
+		PubDate:   msg.PubDate.UTC().Truncate(time.Second),
+		User:      msg.User,
+	}
 }
 
-type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+func toMessageResponses(messages []APIMessage) []MessageResponse {
+	responses := make([]MessageResponse, 0, len(messages))
+	for _, msg := range messages {
+		responses = append(responses, toMessageResponse(msg))
+	}
+	return responses
 }
 
 type User struct {
@@ -28,17 +72,32 @@ type User struct {
 }
 
 type Message struct {
-	MessageID uint   `gorm:"primaryKey"`
-	AuthorID  uint   `gorm:"not null"`
-	Author    User   `gorm:"foreignKey:AuthorID;references:UserID"`
-	Text      string `gorm:"not null"`
-	PubDate   string `gorm:"not null"`
-	Flagged   uint   `gorm:"default:0"`
+	MessageID uint      `gorm:"primaryKey"`
+	AuthorID  uint      `gorm:"not null"`
+	Author    User      `gorm:"foreignKey:AuthorID;references:UserID"`
+	Text      string    `gorm:"not null"`
+	PubDate   time.Time `gorm:"not null"`
+	Flagged   uint      `gorm:"default:0"`
 }
 
+// MessageFlag records a moderator flagging or unflagging a message.
+type MessageFlag struct {
+	FlagID    uint      `gorm:"primaryKey" json:"-"`
+	MessageID uint      `gorm:"not null;index:idx_message_flags_message_id" json:"message_id"`
+	Action    string    `gorm:"not null" json:"action"` // FLAG_ACTION_FLAG or FLAG_ACTION_UNFLAG
+	Moderator string    `gorm:"not null" json:"moderator"`
+	Reason    string    `gorm:"not null;default:''" json:"reason"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+}
+
+const (
+	FLAG_ACTION_FLAG   = "flag"
+	FLAG_ACTION_UNFLAG = "unflag"
+)
+
 type Follower struct {
-	WhoID  uint `gorm:"not null"`
+	WhoID  uint `gorm:"primaryKey;autoIncrement:false;not null"`
 	Who    User `gorm:"foreignKey:WhoID;references:UserID"`
-	WhomID uint `gorm:"not null"`
+	WhomID uint `gorm:"primaryKey;autoIncrement:false;not null"`
 	Whom   User `gorm:"foreignKey:WhomID;references:UserID"`
 }
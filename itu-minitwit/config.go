@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"devoops/client"
+	"devoops/config"
+)
+
+// SESSION_KEY_MIN_LENGTH is the shortest session key accepted, in bytes.
+const SESSION_KEY_MIN_LENGTH = 32
+
+// Config is everything the frontend reads at startup. Settings start at defaultConfig,
+// can be set in the YAML file named by --config or CONFIG_FILE, and are overridden by
+// their environment variable.
+type Config struct {
+	Mode         string        `yaml:"mode" env:"MODE"` // production or dev
+	Port         string        `yaml:"port" env:"PORT"`
+	Endpoint     string        `yaml:"endpoint" env:"ENDPOINT"` // base URL of the API
+	ServiceToken string        `yaml:"service_token" env:"SERVICE_TOKEN" secret:"true"`
+	APITimeout   time.Duration `yaml:"api_timeout" env:"API_TIMEOUT"`
+	// SessionKey signs the session cookie. It is required outside dev mode; without one a
+	// random key is generated, so sessions do not survive a restart.
+	SessionKey string              `yaml:"session_key" env:"SESSION_KEY" secret:"true"`
+	Server     config.ServerConfig `yaml:"server"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Mode:       config.MODE_PRODUCTION,
+		Port:       ":8080",
+		Endpoint:   "http://localhost:9090",
+		APITimeout: client.DEFAULT_TIMEOUT,
+		Server:     config.DefaultServerConfig(),
+	}
+}
+
+// loadConfig reads the configuration and checks it, reporting every invalid setting.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	if err := errors.Join(config.Load(path, &cfg), cfg.Validate()); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Mode == config.MODE_PRODUCTION || c.Mode == config.MODE_DEV,
+		"MODE must be %s or %s, got %q", config.MODE_PRODUCTION, config.MODE_DEV, c.Mode)
+	_, _, err := net.SplitHostPort(c.Port)
+	check(err == nil, "PORT must be an address such as :8080, got %q", c.Port)
+	endpoint, err := url.Parse(c.Endpoint)
+	check(err == nil && (endpoint.Scheme == "http" || endpoint.Scheme == "https") && endpoint.Host != "",
+		"ENDPOINT must be an http or https URL, got %q", c.Endpoint)
+	check(c.APITimeout > 0, "API_TIMEOUT must be positive")
+	check(c.Mode == config.MODE_DEV || c.ServiceToken != "", "SERVICE_TOKEN is required outside dev mode")
+	check(c.Mode == config.MODE_DEV || c.SessionKey != "", "SESSION_KEY is required outside dev mode")
+	check(c.SessionKey == "" || len(c.SessionKey) >= SESSION_KEY_MIN_LENGTH,
+		"SESSION_KEY must be at least %d bytes", SESSION_KEY_MIN_LENGTH)
+
+	if err := c.Server.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -2,11 +2,25 @@ module minitwit
 
 go 1.23.6
 
-require github.com/gorilla/sessions v1.4.0
+require (
+	devoops/client v0.0.0
+	devoops/config v0.0.0
+	devoops/contract v0.0.0
+	devoops/httpserver v0.0.0
+	github.com/gorilla/mux v1.8.1
+	github.com/gorilla/sessions v1.4.0
+	github.com/mattn/go-sqlite3 v1.14.24
+)
 
 require (
-	github.com/gorilla/mux v1.8.1 // indirect
 	github.com/gorilla/securecookie v1.1.2 // indirect
-	github.com/mattn/go-sqlite3 v1.14.24 // indirect
 	golang.org/x/crypto v0.33.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace (
+	devoops/client => ../client
+	devoops/config => ../config
+	devoops/contract => ../contract
+	devoops/httpserver => ../httpserver
 )
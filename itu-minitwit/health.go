@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"devoops/client"
+	"devoops/contract"
+	"devoops/httpserver"
+)
+
+// HEALTH_CHECK_TIMEOUT bounds the readiness check, so a hanging API reports as down
+// instead of hanging the probe.
+const HEALTH_CHECK_TIMEOUT = 2 * time.Second
+
+// HealthHandler answers as long as the process serves requests.
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, contract.HealthResponse{Status: contract.HEALTH_OK})
+}
+
+// ReadyHandler answers 200 once the API at the configured endpoint can be reached, and 503
+// again while server drains on shutdown.
+func ReadyHandler(server *httpserver.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if server.Draining() {
+			writeHealth(w, contract.HealthResponse{Status: contract.HEALTH_DOWN, Checks: map[string]contract.HealthCheck{
+				"server": {Status: contract.HEALTH_DOWN, Error: "shutting down"},
+			}})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), HEALTH_CHECK_TIMEOUT)
+		defer cancel()
+
+		start := time.Now()
+		_, err := apiClient.Health(client.WithRequestID(ctx, requestID(r)))
+		check := contract.HealthCheck{Status: contract.HEALTH_OK, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+		response := contract.HealthResponse{Status: contract.HEALTH_OK, Checks: map[string]contract.HealthCheck{"api": check}}
+		if err != nil {
+			requestLogger(r).Printf("Readiness check failed: %v", err)
+			check.Status, check.Error = contract.HEALTH_DOWN, err.Error()
+			response.Status, response.Checks["api"] = contract.HEALTH_DOWN, check
+		}
+		writeHealth(w, response)
+	}
+}
+
+func writeHealth(w http.ResponseWriter, response contract.HealthResponse) {
+	status := http.StatusOK
+	if response.Status != contract.HEALTH_OK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
 	"net/http"
-	"net/url"
-	"strconv"
+
+	"devoops/client"
+	"devoops/contract"
+	"github.com/gorilla/sessions"
 )
 
 // Taken from https://gowebexamples.com/password-hashing/
@@ -15,70 +18,66 @@ func Error() string {
 	return "An error occurred."
 }
 
-func getUserDetailsByID(w http.ResponseWriter, userID int, userDetails *UserDetails) error {
-	baseURL := fmt.Sprintf("%s/%s", ENDPOINT, "/getUserDetails")
-	u, err := url.Parse(baseURL)
-	if err != nil {
-		fmt.Print(err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return err
-	}
+// REQUEST_ID_HEADER carries the id that links a page request to the API calls made for it.
+const REQUEST_ID_HEADER = contract.REQUEST_ID_HEADER
+
+type requestIDKey struct{}
+
+// RequestIDMiddleware keeps the X-Request-ID of a proxy in front of us, or generates one.
+// Ids the API would not accept are replaced here, so the API keeps the one we send.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !contract.ValidRequestID(id) {
+			raw := make([]byte, 16)
+			rand.Read(raw)
+			id = hex.EncodeToString(raw)
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns a logger that prefixes its lines with the request id, for logs
+// about a request.
+func requestLogger(r *http.Request) *log.Logger {
+	return log.New(log.Writer(), "request_id="+requestID(r)+" ", log.Flags()|log.Lmsgprefix)
+}
+
+// apiContext is the context to call the API with for r, carrying its request id so API
+// logs can be matched with ours.
+func apiContext(r *http.Request) context.Context {
+	return client.WithRequestID(r.Context(), requestID(r))
+}
 
-	// Add query parameters
-	queryParams := url.Values{}
-	//fmt.Print("Remember to change back to userID, %d", userID)
-	queryParams.Add("user_id", strconv.Itoa(userID))
+// userContext additionally carries the token the API issued to the logged in user, which
+// the API requires for routes that act on behalf of that user.
+func userContext(r *http.Request, session *sessions.Session) context.Context {
+	return client.WithToken(apiContext(r), sessionToken(session))
+}
 
-	u.RawQuery = queryParams.Encode()
-	u.Query()
-	res, err := http.Get(u.String())
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return err
-	}
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println("Error reading response body:", err)
-		return err
-	}
-	defer res.Body.Close()
-	err = json.Unmarshal(body, &userDetails)
-	if err != nil {
-		fmt.Println("Error unmarshalling JSON:", err)
-		return err
-	}
-	return nil
+// sessionToken returns the API token stored at login, or "" for sessions created
+// before tokens were introduced.
+func sessionToken(session *sessions.Session) string {
+	token, _ := session.Values["token"].(string)
+	return token
 }
-func getUserDetailsByUsername(w http.ResponseWriter, username string, userDetails *UserDetails) error {
-	baseURL := fmt.Sprintf("%s/%s", ENDPOINT, "/getUserDetails")
-	u, err := url.Parse(baseURL)
-	if err != nil {
-		fmt.Print(err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return err
-	}
 
-	// Add query parameters
-	queryParams := url.Values{}
-	queryParams.Add("username", username)
+// expireSession logs the user out when the API no longer accepts their token.
+func expireSession(w http.ResponseWriter, r *http.Request, session *sessions.Session) {
+	delete(session.Values, "user_id")
+	delete(session.Values, "token")
+	session.AddFlash("Your session has expired, please sign in again")
+	session.Save(r, w)
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
 
-	u.RawQuery = queryParams.Encode()
-	u.Query()
-	res, err := http.Get(u.String())
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return err
-	}
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println("Error reading response body:", err)
-		return err
-	}
-	defer res.Body.Close()
-	err = json.Unmarshal(body, &userDetails)
-	if err != nil {
-		fmt.Println("Error unmarshalling JSON:", err)
-		return err
-	}
-	return nil
+// pageOf forwards the older/newer cursor of a timeline page to the API.
+func pageOf(r *http.Request) client.Page {
+	return client.Page{Before: r.URL.Query().Get("before"), After: r.URL.Query().Get("after")}
 }
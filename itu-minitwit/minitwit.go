@@ -1,29 +1,40 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/md5"
+	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	_ "time/tzdata" // viewer time zones, independent of the container's zoneinfo
 
+	"devoops/client"
+	"devoops/config"
+	"devoops/contract"
+	"devoops/httpserver"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var ENDPOINT = "http://localhost:9090"
+// apiClient calls the API at the configured endpoint as the frontend service.
+var apiClient *client.Client
 
-var store = sessions.NewCookieStore([]byte("SESSION_KEY"))
+// store keeps the sessions in cookies signed with the configured session key.
+var store *sessions.CookieStore
 
 // Gravatar function that generates the Gravatar URL based on the email
 func Gravatar(size int, email string) string {
@@ -34,15 +45,57 @@ func Gravatar(size int, email string) string {
 	return fmt.Sprintf("https://www.gravatar.com/avatar/%s?d=identicon&s=%d", hex.EncodeToString(hash.Sum(nil)), size)
 }
 
-func FormatDateTime(timestamp int64) string {
-	t := time.Unix(timestamp, 0)
-	return t.Format("Jan 2, 2006 at 3:04PM")
+// FormatDateTime renders a pub_date from the API in the viewer's time zone.
+func FormatDateTime(pubDate time.Time, loc *time.Location) string {
+	return pubDate.In(loc).Format("Jan 2, 2006 at 3:04PM")
 }
 
-func renderTemplate(w http.ResponseWriter, tmpl string, data interface{}) {
+// RelativeTime renders a pub_date as e.g. "5 minutes ago".
+func RelativeTime(t time.Time) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	switch d := time.Since(t); {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	case d < 30*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day")
+	default:
+		return t.Format("Jan 2, 2006")
+	}
+}
+
+// viewerLocation is the time zone the browser reported in the tz cookie, set by
+// layout.html. It falls back to UTC.
+func viewerLocation(r *http.Request) *time.Location {
+	cookie, err := r.Cookie("tz")
+	if err != nil {
+		return time.UTC
+	}
+	name, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data interface{}) {
+	loc := viewerLocation(r)
 
 	tmpls := template.New("").Funcs(template.FuncMap{
-		"FormatDateTime": FormatDateTime,
+		"FormatDateTime": func(pubDate time.Time) string { return FormatDateTime(pubDate, loc) },
+		"RelativeTime":   RelativeTime,
 		"Gravatar":       Gravatar,
 	})
 
@@ -62,7 +115,7 @@ func renderTemplate(w http.ResponseWriter, tmpl string, data interface{}) {
 
 // Fetch the TimelineHandler messages
 func TimelineHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("We got a visitor from:", r.RemoteAddr)
+	requestLogger(r).Println("We got a visitor from:", r.RemoteAddr)
 
 	session, _ := store.Get(r, "session-name")
 
@@ -73,55 +126,35 @@ func TimelineHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get user data
-	var userDetails UserDetails
-	err := getUserDetailsByID(w, userID, &userDetails)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-	}
-
-	baseURL := fmt.Sprintf("%s/followingmsgs", ENDPOINT)
-	u, err := url.Parse(baseURL)
+	userDetails, err := apiClient.UserByID(apiContext(r), uint(userID))
 	if err != nil {
-		fmt.Print(err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	queryParams := url.Values{}
-	queryParams.Add("userid", strconv.Itoa(userDetails.UserID))
-	u.RawQuery = queryParams.Encode()
-	u.Query()
-	res, err := http.Get(u.String())
+
 	// Query the API for messages
-	// Get url
-	// Send request
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	page, err := apiClient.Timeline(userContext(r, session), userDetails.UserID, pageOf(r))
+	if errors.Is(err, client.ErrUnauthorized) {
+		expireSession(w, r, session)
 		return
 	}
-	defer res.Body.Close()
-
-	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		fmt.Println("Error reading response body:", err)
+		requestLogger(r).Println("Error fetching timeline:", err)
+		http.Error(w, "Could not load timeline", http.StatusBadGateway)
 		return
 	}
-
-	var messages []Message
-	err = json.Unmarshal(body, &messages)
-	if err != nil {
-		fmt.Println("test")
-		fmt.Println("Error unmarshalling JSON:", err)
-	}
 	flashes := session.Flashes() // Get flash messages
 	session.Save(r, w)
 
 	// Render template
-	renderTemplate(w, "timeline", map[string]interface{}{
-		"User":     userDetails,
-		"username": userID,
-		"messages": messages,
-		"Flashes":  flashes,
-		"Endpoint": "timeline",
+	renderTemplate(w, r, "timeline", map[string]interface{}{
+		"User":        userDetails,
+		"username":    userID,
+		"messages":    page.Messages,
+		"Flashes":     flashes,
+		"Endpoint":    "timeline",
+		"OlderCursor": page.Next,
+		"NewerCursor": page.Prev,
 	})
 
 }
@@ -134,7 +167,8 @@ func PublicTimelineHandler(w http.ResponseWriter, r *http.Request) {
 	var userDetails UserDetails
 
 	if ok {
-		err := getUserDetailsByID(w, userID, &userDetails)
+		var err error
+		userDetails, err = apiClient.UserByID(apiContext(r), uint(userID))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -142,26 +176,10 @@ func PublicTimelineHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Query the API for messages
-	// Get url
-	url := fmt.Sprintf("%s/msgs", ENDPOINT)
-	// Send request
-	res, err := http.Get(url)
+	page, err := apiClient.Messages(apiContext(r), pageOf(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	defer res.Body.Close()
-
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println("Error reading response body:", err)
-		return
-	}
-
-	var messages []Message
-	err = json.Unmarshal(body, &messages)
-	if err != nil {
-		fmt.Println("Error unmarshalling JSON:", err)
+		requestLogger(r).Println("Error fetching messages:", err)
+		http.Error(w, "Could not load messages", http.StatusBadGateway)
 		return
 	}
 	flashes := session.Flashes() // Get flash messages
@@ -170,83 +188,168 @@ func PublicTimelineHandler(w http.ResponseWriter, r *http.Request) {
 	// Render template depending on whether the user is logged in or not
 
 	if !ok {
-		renderTemplate(w, "timeline", map[string]interface{}{
-			"messages": messages,
-			"Flashes":  flashes,
-			"Endpoint": "public_timeline",
+		renderTemplate(w, r, "timeline", map[string]interface{}{
+			"messages":    page.Messages,
+			"Flashes":     flashes,
+			"Endpoint":    "public_timeline",
+			"OlderCursor": page.Next,
+			"NewerCursor": page.Prev,
 		})
 	} else {
-		renderTemplate(w, "timeline", map[string]interface{}{
-			"messages": messages,
-			"Flashes":  flashes,
-			"User":     userDetails,
-			"Endpoint": "public_timeline",
+		renderTemplate(w, r, "timeline", map[string]interface{}{
+			"messages":    page.Messages,
+			"Flashes":     flashes,
+			"User":        userDetails,
+			"Endpoint":    "public_timeline",
+			"OlderCursor": page.Next,
+			"NewerCursor": page.Prev,
 		})
 	}
 
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+// SearchHandler shows the messages matching ?q= using the timeline template.
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := store.Get(r, "session-name")
 
-	// If user is already in the cookies, just redirect
-	if session.Values["user_id"] != nil {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
+	userID, ok := session.Values["user_id"].(int)
+
+	var userDetails UserDetails
+
+	if ok {
+		var err error
+		userDetails, err = apiClient.UserByID(apiContext(r), uint(userID))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
-	if r.Method == "POST" {
-		data := map[string]string{
-			"Username": r.FormValue("username"),
-			"Password": r.FormValue("password")}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	data := map[string]interface{}{
+		"Endpoint": "search",
+		"Query":    q,
+		"Title":    "Search",
+	}
+	if ok {
+		data["User"] = userDetails
+	}
+
+	if q != "" {
+		page, err := apiClient.Search(apiContext(r), q, pageOf(r))
+		if err != nil {
+			requestLogger(r).Println("Error searching messages:", err)
+			http.Error(w, "Search failed", http.StatusBadGateway)
+			return
+		}
+		data["messages"] = page.Messages
+		data["OlderCursor"] = page.Next
+		data["NewerCursor"] = page.Prev
+		data["Title"] = fmt.Sprintf("Results for \"%s\"", q)
+	}
 
-		jsonData, err := json.Marshal(data)
+	data["Flashes"] = session.Flashes()
+	session.Save(r, w)
+	renderTemplate(w, r, "timeline", data)
+}
 
-		url := fmt.Sprintf("%s/login", ENDPOINT)
+// MessageHandler is the permalink page of a single message.
+func MessageHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
 
+	userID, ok := session.Values["user_id"].(int)
+
+	var userDetails UserDetails
+
+	if ok {
+		var err error
+		userDetails, err = apiClient.UserByID(apiContext(r), uint(userID))
 		if err != nil {
-			fmt.Println("Error marshalling JSON:", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	message, err := apiClient.Message(apiContext(r), uint(id))
+	if errors.Is(err, client.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		requestLogger(r).Println("Error fetching message:", err)
+		http.Error(w, "Could not load message", http.StatusBadGateway)
+		return
+	}
 
-		// Send POST request
-		resp, _ := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
-		if resp.StatusCode == http.StatusOK {
-			var userdetails UserDetails
-			err := getUserDetailsByUsername(w, r.FormValue("username"), &userdetails)
+	data := map[string]interface{}{
+		"messages": []Message{message},
+		"Endpoint": "message",
+		"Title":    "Message by " + message.User,
+		"Flashes":  session.Flashes(),
+	}
+	if ok {
+		data["User"] = userDetails
+	}
+	session.Save(r, w)
+	renderTemplate(w, r, "timeline", data)
+}
+
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session-name")
+
+	// If user is already in the cookies, just redirect
+	if session.Values["user_id"] != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	if r.Method == "POST" {
+		login, err := apiClient.Login(apiContext(r), r.FormValue("username"), r.FormValue("password"))
+		if err == nil {
+			userdetails, err := apiClient.UserByUsername(apiContext(r), r.FormValue("username"))
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 
 			session.AddFlash("You were logged in")
-			session.Values["user_id"] = userdetails.UserID
+			session.Values["user_id"] = int(userdetails.UserID)
+			session.Values["token"] = login.Token
 			session.Save(r, w)
 			http.Redirect(w, r, "/", http.StatusFound)
 			return
 		} else {
-			if resp.StatusCode == http.StatusNotFound {
-				renderTemplate(w, "login", map[string]interface{}{
-					"Error": "Invalid username",
-				})
-				return
-			} else if resp.StatusCode == http.StatusUnauthorized {
-				renderTemplate(w, "login", map[string]interface{}{
-					"Error": "Invalid password",
-				})
-				return
-			} else {
-				renderTemplate(w, "login", map[string]interface{}{
-					"Error": "Invalid credentials",
-				})
+			var apiErr *client.Error
+			if !errors.As(err, &apiErr) {
+				http.Error(w, err.Error(), http.StatusBadGateway)
 				return
 			}
+			var error string
+			switch apiErr.Code {
+			case contract.ERR_USER_NOT_FOUND:
+				error = "Invalid username"
+			case contract.ERR_INVALID_PASSWORD:
+				error = "Invalid password"
+			case contract.ERR_VALIDATION:
+				error = apiErr.ErrorMsg
+			default:
+				error = "Invalid credentials"
+			}
+			renderTemplate(w, r, "login", map[string]interface{}{
+				"Error": error,
+			})
+			return
 		}
 	}
 	flashes := session.Flashes()
 	session.Save(r, w)
 
-	renderTemplate(w, "login", map[string]interface{}{
+	renderTemplate(w, r, "login", map[string]interface{}{
 		"Flashes": flashes,
 	})
 }
@@ -263,58 +366,47 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	var error string
 
 	if r.Method == "POST" {
-		// If there is username in the form
-		if r.FormValue("username") == "" {
-			error = "You have to enter a username"
-			// If the email is missing
-		} else if r.FormValue("email") == "" {
-			error = "You have to enter a valid email address"
-			// If the email is address is invalid
-		} else if !strings.Contains(r.FormValue("email"), "@") {
-			error = "You have to enter a valid email address"
-			// If there is not a password
-		} else if r.FormValue("password") == "" {
-			error = "You have to enter a password"
+		registration := contract.RegisterRequest{
+			Username: r.FormValue("username"),
+			Email:    r.FormValue("email"),
+			Password: r.FormValue("password"),
+		}
+		// Check the fields the way the API will, so the form does not round trip for them
+		if err := registration.Validate(); err != nil {
+			error = err.Error()
 			// If the two passwords do not match
 		} else if r.FormValue("password") != r.FormValue("password2") {
 			error = "The two passwords do not match"
 		} else {
-			var userdetails UserDetails
-			err := getUserDetailsByUsername(w, r.FormValue("username"), &userdetails)
+			_, err := apiClient.UserByUsername(apiContext(r), r.FormValue("username"))
 			if err == nil {
 				error = "The username is already taken"
-				log.Println("Error retrieving user ID:", err)
 				data := map[string]interface{}{
 					"Error":    error,
 					"Username": r.FormValue("username"),
 					"Email":    r.FormValue("email"),
 				}
-				renderTemplate(w, "register", data)
+				renderTemplate(w, r, "register", data)
+				return
+			} else if !errors.Is(err, client.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusBadGateway)
 				return
 			} else {
-				url := fmt.Sprintf("%s/register", ENDPOINT) // Adjust based on your server configuration
-
-				// Define request payload
-				data := map[string]string{
-					"username": r.FormValue("username"),
-					"email":    r.FormValue("email"),
-					"pwd":      r.FormValue("password"),
-				}
-				jsonData, _ := json.Marshal(data)
-				req, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+				err := apiClient.Register(apiContext(r), registration)
 				if err != nil {
-					http.Error(w, err.Error(), http.StatusBadRequest)
-					return
-				}
-				if req.StatusCode == http.StatusBadRequest {
-					error = "Error handling your request"
-					log.Println("Error retrieving user ID:", err)
+					var apiErr *client.Error
+					if errors.As(err, &apiErr) && (apiErr.Code == contract.ERR_USERNAME_TAKEN || apiErr.Code == contract.ERR_VALIDATION) {
+						error = apiErr.ErrorMsg
+					} else {
+						error = "Error handling your request"
+					}
+					requestLogger(r).Println("Error registering user:", err)
 					data := map[string]interface{}{
 						"Error":    error,
 						"Username": r.FormValue("username"),
 						"Email":    r.FormValue("email"),
 					}
-					renderTemplate(w, "register", data)
+					renderTemplate(w, r, "register", data)
 					return
 				}
 				session.AddFlash("You were successfully registered and can login now")
@@ -330,12 +422,13 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		"Username": r.FormValue("username"),
 		"Email":    r.FormValue("email"),
 	}
-	renderTemplate(w, "register", data)
+	renderTemplate(w, r, "register", data)
 }
 
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := store.Get(r, "session-name")
 	delete(session.Values, "user_id")
+	delete(session.Values, "token")
 	session.AddFlash("You were logged out")
 	session.Save(r, w)
 	http.Redirect(w, r, "/", http.StatusFound)
@@ -352,8 +445,7 @@ func AddMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get user details
-	var userDetails UserDetails
-	err := getUserDetailsByID(w, userID, &userDetails)
+	userDetails, err := apiClient.UserByID(apiContext(r), uint(userID))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -366,16 +458,11 @@ func AddMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	url := fmt.Sprintf("%s/msgs/%s", ENDPOINT, userDetails.Username)
-	data := map[string]string{"content": messageText}
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		fmt.Println("Error marshalling JSON:", err)
+	err = apiClient.PostMessage(userContext(r, session), userDetails.Username, messageText)
+	if errors.Is(err, client.ErrUnauthorized) {
+		expireSession(w, r, session)
 		return
 	}
-
-	_, err = http.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	// Insert the message into the database
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -392,31 +479,29 @@ func FollowHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User not logged in", http.StatusUnauthorized)
 		return
 	}
-	var userDetails UserDetails
-	err := getUserDetailsByID(w, session.Values["user_id"].(int), &userDetails)
+	userDetails, err := apiClient.UserByID(apiContext(r), uint(session.Values["user_id"].(int)))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	url := fmt.Sprintf("%s/fllws/%s", ENDPOINT, vars["username"])
-	data := map[string]string{"follow": userDetails.Username}
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		fmt.Println("Error marshalling JSON:", err)
-		return
-	}
-
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
 
-	if err != nil {
-		fmt.Println("Error marshalling JSON:", err)
+	result, err := apiClient.Follow(userContext(r, session), userDetails.Username, vars["username"])
+	if errors.Is(err, client.ErrUnauthorized) {
+		expireSession(w, r, session)
 		return
 	}
-	if resp.StatusCode != 200 {
+	var apiErr *client.Error
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code == contract.ERR_CANNOT_FOLLOW_SELF:
+		session.AddFlash("You cannot follow yourself")
+	case err != nil:
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
+	case result.Status == contract.FOLLOW_STATUS_ALREADY_FOLLOWING:
+		session.AddFlash("You are already following " + vars["username"])
+	default:
+		session.AddFlash("You are now following " + vars["username"])
 	}
-	session.AddFlash("You are now following " + vars["username"])
 	session.Save(r, w)
 	http.Redirect(w, r, fmt.Sprintf("/user_timeline/%s", vars["username"]), http.StatusFound)
 
@@ -429,31 +514,26 @@ func UnfollowHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User not logged in", http.StatusUnauthorized)
 		return
 	}
-	var userDetails UserDetails
-	err := getUserDetailsByID(w, session.Values["user_id"].(int), &userDetails)
+	userDetails, err := apiClient.UserByID(apiContext(r), uint(session.Values["user_id"].(int)))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	url := fmt.Sprintf("%s/fllws/%s", ENDPOINT, vars["username"])
-	data := map[string]string{"unfollow": userDetails.Username}
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		fmt.Println("Error marshalling JSON:", err)
-		return
-	}
-
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
 
-	if err != nil {
-		fmt.Println("Error marshalling JSON:", err)
+	result, err := apiClient.Unfollow(userContext(r, session), userDetails.Username, vars["username"])
+	if errors.Is(err, client.ErrUnauthorized) {
+		expireSession(w, r, session)
 		return
 	}
-	if resp.StatusCode != 200 {
+	if err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	session.AddFlash("You are no longer following " + vars["username"]) // TODO: Don't know if working
+	if result.Status == contract.FOLLOW_STATUS_NOT_FOLLOWING {
+		session.AddFlash("You are not following " + vars["username"])
+	} else {
+		session.AddFlash("You are no longer following " + vars["username"])
+	}
 	session.Save(r, w)
 	http.Redirect(w, r, fmt.Sprintf("/user_timeline/%s", vars["username"]), http.StatusFound)
 
@@ -467,109 +547,102 @@ func UserTimelineHandler(w http.ResponseWriter, r *http.Request) {
 	var userDetails UserDetails
 
 	if ok {
-		err := getUserDetailsByID(w, userID, &userDetails)
+		var err error
+		userDetails, err = apiClient.UserByID(apiContext(r), uint(userID))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
 
-	// vars["username"]
-	var profile_user UserDetails
-	err := getUserDetailsByUsername(w, vars["username"], &profile_user)
+	profile_user, err := apiClient.UserByUsername(apiContext(r), vars["username"])
+	if errors.Is(err, client.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	var isFollowing bool
-	if session.Values["user_id"] != nil {
+	if ok {
 		// Get if the user is following
-		baseURL := fmt.Sprintf("%s/%s", ENDPOINT, "/isfollowing")
-		u, err := url.Parse(baseURL)
-		if err != nil {
-			fmt.Print(err.Error())
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		queryParams := url.Values{}
-		queryParams.Add("whoUsername", profile_user.Username)
-		queryParams.Add("whomUsername", userDetails.Username)
-		u.RawQuery = queryParams.Encode()
-		u.Query()
-		res, err := http.Get(u.String())
+		isFollowing, err = apiClient.IsFollowing(apiContext(r), userDetails.Username, profile_user.Username)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		body, err := io.ReadAll(res.Body)
-		if err != nil {
-			fmt.Println("Error reading response body:", err)
-			return
-		}
-		defer res.Body.Close()
-		err = json.Unmarshal(body, &isFollowing)
-		if err != nil {
-			fmt.Println("Error unmarshalling JSON:", err)
-			return
-		}
 	}
 
 	// Request the API for messages
-	url := fmt.Sprintf("%s/msgs/%s", ENDPOINT, profile_user.Username)
-	res, err := http.Get(url)
+	page, err := apiClient.UserMessages(apiContext(r), profile_user.Username, pageOf(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		requestLogger(r).Println("Error fetching messages:", err)
+		http.Error(w, "Could not load messages", http.StatusBadGateway)
 		return
 	}
-	defer res.Body.Close()
-
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println("Error reading response body:", err)
-		return
-	}
-
-	var messages []Message
-	err = json.Unmarshal(body, &messages)
-	if err != nil {
-		fmt.Println("Error unmarshalling JSON:", err)
-	}
 
 	flashes := session.Flashes() // Get flash messages
 	session.Save(r, w)           // Clear them after retrieval
 
 	// render template based on whether user is logged in or not
 	if ok {
-		renderTemplate(w, "timeline", map[string]interface{}{
+		renderTemplate(w, r, "timeline", map[string]interface{}{
 			"User":        userDetails,
 			"ProfileUser": profile_user,
 			"Followed":    isFollowing,
-			"messages":    messages,
+			"messages":    page.Messages,
 			"Endpoint":    "user_timeline",
 			"Flashes":     flashes,
+			"OlderCursor": page.Next,
+			"NewerCursor": page.Prev,
 		})
 	} else {
-		renderTemplate(w, "timeline", map[string]interface{}{
+		renderTemplate(w, r, "timeline", map[string]interface{}{
 			"ProfileUser": profile_user,
 			"Followed":    isFollowing,
-			"messages":    messages,
+			"messages":    page.Messages,
 			"Endpoint":    "user_timeline",
 			"Flashes":     flashes,
+			"OlderCursor": page.Next,
+			"NewerCursor": page.Prev,
 		})
 	}
 
 }
 
-func getEndpoint() string {
-	defaultEndpoint := "http://localhost:9090" // Default if ENDPOINT is not set
-	if endpoint, exists := os.LookupEnv("ENDPOINT"); exists {
-		return endpoint
+func main() {
+	flags := flag.NewFlagSet("minitwit", flag.ExitOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file (CONFIG_FILE)")
+	printConfig := flags.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flags.Parse(os.Args[1:])
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if *printConfig {
+		if err := config.Write(os.Stdout, cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	return defaultEndpoint
-}
 
-func main() {
-	ENDPOINT = getEndpoint()
+	if cfg.ServiceToken == "" {
+		log.Println("SERVICE_TOKEN is not set, the API will reject the frontend's requests")
+	}
+	apiClient = client.New(cfg.Endpoint, client.WithServiceToken(cfg.ServiceToken), client.WithTimeout(cfg.APITimeout))
+
+	sessionKey := []byte(cfg.SessionKey)
+	if len(sessionKey) == 0 {
+		log.Println("SESSION_KEY is not set, using a random key")
+		sessionKey = make([]byte, SESSION_KEY_MIN_LENGTH)
+		if _, err := rand.Read(sessionKey); err != nil {
+			log.Fatalf("Failed to generate a session key: %v", err)
+		}
+	}
+	store = sessions.NewCookieStore(sessionKey)
 	store.Options = &sessions.Options{
 		Path:     "/",
 		MaxAge:   3600 * 16, // 16 hours
@@ -578,6 +651,7 @@ func main() {
 	}
 
 	r := mux.NewRouter()
+	r.Use(RequestIDMiddleware)
 
 	// Serve static files (e.g., CSS, images, etc.) from the "static" folder
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
@@ -592,8 +666,22 @@ func main() {
 	r.HandleFunc("/logout", LogoutHandler).Methods("GET")                         // Done
 	r.HandleFunc("/{username}/follow", FollowHandler).Methods("GET")
 	r.HandleFunc("/{username}/unfollow", UnfollowHandler).Methods("GET")
+	r.HandleFunc("/search", SearchHandler).Methods("GET")
+	r.HandleFunc("/message/{id:[0-9]+}", MessageHandler).Methods("GET")
+	r.HandleFunc("/healthz", HealthHandler).Methods("GET")
+	server := httpserver.New(cfg.Server)
+	r.HandleFunc("/readyz", ReadyHandler(server)).Methods("GET")
+
+	listener, err := net.Listen("tcp", cfg.Port)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", cfg.Port, err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
 	// Start the server on port 8080
-	fmt.Println("Server starting on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	fmt.Printf("Server starting on http://localhost%s\n", cfg.Port)
+	if err := server.Serve(ctx, listener, r); err != nil {
+		log.Printf("Server stopped with an error: %v", err)
+	}
 }
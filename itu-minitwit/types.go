@@ -1,13 +1,8 @@
 package main
 
-type Message struct {
-	Text     string `json:"content"`
-	PubDate  string `json:"pub_date"`
-	Username string `json:"user"`
-}
+import "devoops/contract"
 
-type UserDetails struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-}
+// The pages render the API's bodies as the contract module defines them.
+type Message = contract.Message
+
+type UserDetails = contract.UserDetails
@@ -20,23 +20,6 @@ HEADERS = {
     f'Authorization': f'Basic {ENCODED_CREDENTIALS}'
 }
 
-def test_latest():
-    # post something to update LATEST
-    url = f"{BASE_URL}/register"
-    data = {'username': 'test', 'email': 'test@test', 'pwd': 'foo'}
-    params = {'latest': 1337}
-    response = requests.post(url, data=json.dumps(data),
-                             params=params, headers=HEADERS)
-    assert response.ok
-
-    # verify that latest was updated
-    url = f'{BASE_URL}/latest'
-    response = requests.get(url, headers=HEADERS)
-    assert response.ok
-    assert response.json()['latest'] == 1337
-
-
-
 def test_register():
     username = 'a'
     email = 'a@a.a'
@@ -58,7 +41,7 @@ def test_register_d():
     email = 'a@a.a'
     pwd = 'a'
     data = {'username': username, 'email': email, 'pwd': pwd}
-    params = {'latest': 1}
+    params = {'latest': 2}
     response = requests.post(f'{BASE_URL}/register',
                              data=json.dumps(data), headers=HEADERS, params=params)
    # Assert that the response status code is 400 (Bad Request)
@@ -68,13 +51,13 @@ def test_register_d():
 
     # verify that latest was updated
     response = requests.get(f'{BASE_URL}/latest', headers=HEADERS)
-    assert response.json()['latest'] == 1
+    assert response.json()['latest'] == 2
     
 def test_create_msg():
     username = 'a'
     data = {'content': 'Blub!'}
     url = f'{BASE_URL}/msgs/{username}'
-    params = {'latest': 2}
+    params = {'latest': 3}
     for i in range(10):   
         response = requests.post(url, data=json.dumps(data),
                              headers=HEADERS, params=params)
@@ -82,13 +65,13 @@ def test_create_msg():
 
     # verify that latest was updated
     response = requests.get(f'{BASE_URL}/latest', headers=HEADERS)
-    assert response.json()['latest'] == 2
+    assert response.json()['latest'] == 3
 
 
 def test_get_latest_user_msgs():
     username = 'a'
 
-    query = {'no': 20, 'latest': 3}
+    query = {'no': 20, 'latest': 4}
     url = f'{BASE_URL}/msgs/{username}'
     response = requests.get(url, headers=HEADERS, params=query)
     assert response.status_code == 200
@@ -102,12 +85,12 @@ def test_get_latest_user_msgs():
 
     # verify that latest was updated
     response = requests.get(f'{BASE_URL}/latest', headers=HEADERS)
-    assert response.json()['latest'] == 3
+    assert response.json()['latest'] == 4
 
 
 def test_get_latest_msgs():
     username = 'a'
-    query = {'no': 20, 'latest': 4}
+    query = {'no': 20, 'latest': 5}
     url = f'{BASE_URL}/msgs'
     response = requests.get(url, headers=HEADERS, params=query)
     assert response.status_code == 200
@@ -121,7 +104,7 @@ def test_get_latest_msgs():
 
     # verify that latest was updated
     response = requests.get(f'{BASE_URL}/latest', headers=HEADERS)
-    assert response.json()['latest'] == 4
+    assert response.json()['latest'] == 5
 
 
 def test_register_b():
@@ -129,7 +112,7 @@ def test_register_b():
     email = 'b@b.b'
     pwd = 'b'
     data = {'username': username, 'email': email, 'pwd': pwd}
-    params = {'latest': 5}
+    params = {'latest': 6}
     response = requests.post(f'{BASE_URL}/register', data=json.dumps(data),
                              headers=HEADERS, params=params)
     assert response.ok
@@ -137,7 +120,7 @@ def test_register_b():
 
     # verify that latest was updated
     response = requests.get(f'{BASE_URL}/latest', headers=HEADERS)
-    assert response.json()['latest'] == 5
+    assert response.json()['latest'] == 6
 
 
 def test_register_c():
@@ -145,32 +128,32 @@ def test_register_c():
     email = 'c@c.c'
     pwd = 'c'
     data = {'username': username, 'email': email, 'pwd': pwd}
-    params = {'latest': 6}
+    params = {'latest': 7}
     response = requests.post(f'{BASE_URL}/register', data=json.dumps(data),
                              headers=HEADERS, params=params)
     assert response.ok
 
     # verify that latest was updated
     response = requests.get(f'{BASE_URL}/latest', headers=HEADERS)
-    assert response.json()['latest'] == 6
+    assert response.json()['latest'] == 7
 
 
 def test_follow_user():
     username = 'a'
     url = f'{BASE_URL}/fllws/{username}'
     data = {'follow': 'b'}
-    params = {'latest': 7}
+    params = {'latest': 8}
     response = requests.post(url, data=json.dumps(data),
                              headers=HEADERS, params=params)
     assert response.ok
 
     data = {'follow': 'c'}
-    params = {'latest': 8}
+    params = {'latest': 9}
     response = requests.post(url, data=json.dumps(data),
                              headers=HEADERS, params=params)
     assert response.ok
 
-    query = {'no': 20, 'latest': 9}
+    query = {'no': 20, 'latest': 10}
     response = requests.get(url, headers=HEADERS, params=query)
     assert response.ok
 
@@ -180,7 +163,7 @@ def test_follow_user():
 
     # verify that latest was updated
     response = requests.get(f'{BASE_URL}/latest', headers=HEADERS)
-    assert response.json()['latest'] == 9
+    assert response.json()['latest'] == 10
     
 
 
@@ -190,17 +173,34 @@ def asdtest_a_unfollows_b():
 
     #  first send unfollow command
     data = {'unfollow': 'b'}
-    params = {'latest': 10}
+    params = {'latest': 11}
     response = requests.post(url, data=json.dumps(data),
                              headers=HEADERS, params=params)
     assert response.ok
 
     # then verify that b is no longer in follows list
-    query = {'no': 20, 'latest': 11}
+    query = {'no': 20, 'latest': 12}
     response = requests.get(url, params=query, headers=HEADERS)
     assert response.ok
     assert 'b' not in response.json()['follows']
 
     # verify that latest was updated
     response = requests.get(f'{BASE_URL}/latest', headers=HEADERS)
-    assert response.json()['latest'] == 11
+    assert response.json()['latest'] == 12
+
+
+# Runs last: latest only moves forward, so a high id would fail every later test
+def test_latest():
+    # post something to update LATEST
+    url = f"{BASE_URL}/register"
+    data = {'username': 'test', 'email': 'test@test', 'pwd': 'foo'}
+    params = {'latest': 1337}
+    response = requests.post(url, data=json.dumps(data),
+                             params=params, headers=HEADERS)
+    assert response.ok
+
+    # verify that latest was updated
+    url = f'{BASE_URL}/latest'
+    response = requests.get(url, headers=HEADERS)
+    assert response.ok
+    assert response.json()['latest'] == 1337
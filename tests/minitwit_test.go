@@ -2,17 +2,33 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"os"
+	"slices"
 	"strings"
 	"testing"
+
+	apiclient "devoops/client"
 )
 
 const baseURL = "http://localhost:8080"
 
 // command to run tests: go test -v (while the app is running)
+// with ENDPOINT and SERVICE_TOKEN of the API set, the tests also check what the API stored.
+var api = newAPIClient()
+
+func newAPIClient() *apiclient.Client {
+	endpoint := os.Getenv("ENDPOINT")
+	if endpoint == "" {
+		return nil
+	}
+	return apiclient.New(endpoint, apiclient.WithServiceToken(os.Getenv("SERVICE_TOKEN")))
+}
 
 func createSession() (*http.Client, error) {
 	jar, err := cookiejar.New(nil)
@@ -69,6 +85,11 @@ func TestRegister(t *testing.T) {
 	resp, _ = register("user1", "default", "", "")
 	defer resp.Body.Close()
 	assertContains(t, resp, "The username is already taken")
+	if api != nil {
+		if _, err := api.UserByUsername(context.Background(), "user1"); err != nil {
+			t.Errorf("user1 was not stored: %v", err)
+		}
+	}
 
 	resp, _ = register("", "default", "", "")
 	defer resp.Body.Close()
@@ -85,6 +106,11 @@ func TestRegister(t *testing.T) {
 	resp, _ = register("meh", "foo", "", "broken")
 	defer resp.Body.Close()
 	assertContains(t, resp, "You have to enter a valid email address")
+	if api != nil {
+		if _, err := api.UserByUsername(context.Background(), "meh"); !errors.Is(err, apiclient.ErrNotFound) {
+			t.Errorf("expected meh not to be stored, got %v", err)
+		}
+	}
 }
 
 func TestLoginLogout(t *testing.T) {
@@ -121,6 +147,16 @@ func TestMessageRecording(t *testing.T) {
 	defer resp.Body.Close()
 	assertContains(t, resp, "test message 1")
 	assertContains(t, resp, "&lt;test message 2&gt;")
+	if api != nil {
+		page, err := api.UserMessages(context.Background(), "foo", apiclient.Page{})
+		contents := make([]string, 0, len(page.Messages))
+		for _, message := range page.Messages {
+			contents = append(contents, message.Content)
+		}
+		if err != nil || !slices.Contains(contents, "<test message 2>") {
+			t.Errorf("message was not stored: %v %q", err, contents)
+		}
+	}
 }
 
 func TestTimelines(t *testing.T) {
@@ -148,6 +184,7 @@ func TestTimelines(t *testing.T) {
 	resp, _ = clientBar.Get(baseURL + "/foo/follow")
 	defer resp.Body.Close()
 	assertContains(t, resp, "You are now following foo")
+	assertFollowing(t, "bar", "foo", true)
 
 	resp, _ = clientBar.Get(baseURL + "/")
 	defer resp.Body.Close()
@@ -167,6 +204,7 @@ func TestTimelines(t *testing.T) {
 	resp, _ = clientBar.Get(baseURL + "/foo/unfollow")
 	defer resp.Body.Close()
 	assertContains(t, resp, "You are no longer following foo")
+	assertFollowing(t, "bar", "foo", false)
 
 	resp, _ = clientBar.Get(baseURL + "/")
 	defer resp.Body.Close()
@@ -175,6 +213,16 @@ func TestTimelines(t *testing.T) {
 }
 
 // --- HELPERS ---
+func assertFollowing(t *testing.T, who, whom string, expected bool) {
+	if api == nil {
+		return
+	}
+	following, err := api.IsFollowing(context.Background(), who, whom)
+	if err != nil || following != expected {
+		t.Errorf("Expected %s following %s to be %v, got %v (%v)", who, whom, expected, following, err)
+	}
+}
+
 func assertContains(t *testing.T, resp *http.Response, expected string) {
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {